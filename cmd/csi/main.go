@@ -83,6 +83,36 @@ func main() {
 		&config.NodeID, "nodeid", "", "NodeID to identify the node running this driver",
 	)
 
+	cmd.PersistentFlags().Int64Var(
+		&config.MaxVolumesPerNode, "maxvolumespernode", 0,
+		"Max number of volumes that can be staged on the node, 0 means no limit",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.ISCSI.Interface, "iscsiinterface", "default", "iscsiadm iface to which the iSCSI sessions are bound",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.ISCSI.ReplacementTimeout, "iscsireplacementtimeout", 0, "iSCSI session replacement timeout in seconds, 0 means iscsiadm default",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.ISCSI.NoopOutInterval, "iscsinoopoutinterval", 0, "iSCSI noop-out interval in seconds, 0 means iscsiadm default",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.ISCSI.NoopOutTimeout, "iscsinoopouttimeout", 0, "iSCSI noop-out timeout in seconds, 0 means iscsiadm default",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.ISCSI.QueueDepth, "iscsiqueuedepth", 0, "iSCSI session queue depth, 0 means iscsiadm default",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.NFSServerImage, "nfsserverimage", "itsthenetwork/nfs-server-alpine:12",
+		"Image of the NFS server which serves the ReadWriteMany volumes",
+	)
+
 	cmd.PersistentFlags().StringVar(
 		&config.Version, "version", version.Version, "Displays driver version",
 	)
@@ -125,14 +155,19 @@ func run(config *config.Config) {
 
 	logrus.Infof("%s - %s", version.Version, version.Commit)
 	logrus.Infof(
-		"DriverName: %s Plugin: %s EndPoint: %s NodeID: %s, MaxRetryCount: %v",
+		"DriverName: %s Plugin: %s EndPoint: %s NodeID: %s, MaxRetryCount: %v, MaxVolumesPerNode: %v",
 		config.DriverName,
 		config.PluginType,
 		config.Endpoint,
 		config.NodeID,
 		driver.MaxRetryCount,
+		config.MaxVolumesPerNode,
 	)
 
+	if config.PluginType == "node" {
+		logrus.Infof("iSCSI config: %+v", config.ISCSI)
+	}
+
 	if config.PluginType == "node" && enableISCSIDebug {
 		logrus.SetLevel(logrus.DebugLevel)
 		iscsi.EnableDebugLogging(&log2LogrusWriter{
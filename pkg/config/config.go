@@ -42,6 +42,40 @@ type Config struct {
 	// in case of topologies and publishing or
 	// unpublishing volumes on nodes
 	NodeID string
+
+	// MaxVolumesPerNode is the maximum number of jiva
+	// volumes that can be staged on the node, 0 means
+	// there is no limit
+	MaxVolumesPerNode int64
+
+	// ISCSI is the iSCSI config of the node plugin
+	ISCSI ISCSIConfig
+
+	// NFSServerImage is the image of the NFS server which serves
+	// the RWX volumes, it runs privileged so it is set only by
+	// the administrator of the controller plugin
+	NFSServerImage string
+}
+
+// ISCSIConfig contains the iSCSI interface and session parameters used
+// by the node plugin to login to the jiva targets, the parameters which
+// are not set are left at the iscsiadm defaults
+type ISCSIConfig struct {
+	// Interface is the iscsiadm iface to which the
+	// sessions are bound, e.g. a dedicated storage NIC
+	Interface string
+
+	// ReplacementTimeout is the seconds to wait for the session
+	// to be re-established before failing the IOs
+	ReplacementTimeout int
+
+	// NoopOutInterval and NoopOutTimeout are the seconds
+	// between the nop-out pings and to wait for the reply
+	NoopOutInterval int
+	NoopOutTimeout  int
+
+	// QueueDepth is the max number of outstanding commands
+	QueueDepth int
 }
 
 // Default returns a new instance of config
@@ -0,0 +1,139 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"sort"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// hostnameLabelKey is the node label used by the replica
+	// storage class (local PV) in the node affinity of the PVs
+	hostnameLabelKey = "kubernetes.io/hostname"
+)
+
+// getFreeCapacity returns the free space per node on which the replicas
+// of the given policy can be scheduled. The free space of a node is the
+// allocatable ephemeral storage of the node minus the size of the replica
+// storage class PVs which are pinned to that node
+func getFreeCapacity(
+	ctx context.Context,
+	cli *client.Client,
+	policy *jv.JivaVolumePolicySpec,
+) ([]int64, error) {
+	nodes, err := cli.ListNodes(ctx, policy.Replica.NodeSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	pvs, err := cli.ListPersistentVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]int64{}
+	for _, pv := range pvs.Items {
+		if pv.Spec.StorageClassName != policy.ReplicaSC {
+			continue
+		}
+		size := pv.Spec.Capacity[corev1.ResourceStorage]
+		if hostname := getPVHostname(&pv); hostname != "" {
+			used[hostname] += size.Value()
+		}
+	}
+
+	var free []int64
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable {
+			continue
+		}
+		allocatable, ok := node.Status.Allocatable[corev1.ResourceEphemeralStorage]
+		if !ok {
+			continue
+		}
+		available := allocatable.Value() - used[node.Labels[hostnameLabelKey]]
+		if available > 0 {
+			free = append(free, available)
+		}
+	}
+	return free, nil
+}
+
+// getPVHostname returns the hostname to which the given
+// PV is pinned via the node affinity
+func getPVHostname(pv *corev1.PersistentVolume) string {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return ""
+	}
+
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key == hostnameLabelKey &&
+				expr.Operator == corev1.NodeSelectorOpIn &&
+				len(expr.Values) != 0 {
+				return expr.Values[0]
+			}
+		}
+	}
+	return ""
+}
+
+// getAvailableCapacity returns the total capacity that can be provisioned
+// for the volumes with the given replication factor, each replica of a
+// volume needs to be placed on a different node. So the capacity is the
+// largest C for which sum(min(free[i], C)) >= rf * C
+func getAvailableCapacity(free []int64, rf int) int64 {
+	if rf <= 0 || len(free) < rf {
+		return 0
+	}
+
+	sort.Slice(free, func(i, j int) bool { return free[i] > free[j] })
+
+	var total int64
+	for _, f := range free {
+		total += f
+	}
+
+	fits := func(c int64) bool {
+		var sum int64
+		for _, f := range free {
+			if f < c {
+				sum += f
+			} else {
+				sum += c
+			}
+		}
+		return sum >= int64(rf)*c
+	}
+
+	// binary search the largest capacity which fits
+	low, high := int64(0), total/int64(rf)
+	for low < high {
+		mid := low + (high-low+1)/2
+		if fits(mid) {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	return low
+}
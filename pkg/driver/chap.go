@@ -0,0 +1,42 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// prefixes of the session and discovery CHAP secret keys,
+	// these are the same keys used by the kubernetes iscsi plugin
+	sessionCHAPKeyPrefix   = "node.session.auth."
+	discoveryCHAPKeyPrefix = "discovery.sendtargets.auth."
+)
+
+// validateCHAPSecrets rejects the CHAP credentials in the given CSI
+// secrets, jiva target doesn't support CHAP so the credentials can't be
+// enforced and the volume would be accessible without them
+func validateCHAPSecrets(secrets map[string]string) error {
+	for key := range secrets {
+		if strings.HasPrefix(key, sessionCHAPKeyPrefix) ||
+			strings.HasPrefix(key, discoveryCHAPKeyPrefix) {
+			return fmt.Errorf("secret: {%s} is given, CHAP authentication is not supported by jiva target", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/openebs/jiva-operator/pkg/volume"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	// statsTimeout is the timeout to collect the metrics of the volumes,
+	// the stats of all the volumes are fetched in parallel from their jiva
+	// controllers so it is also the timeout to fetch the stats of a volume
+	statsTimeout = 5 * time.Second
+
+	// nanoSec is used to convert the read/write time
+	// reported by jiva controller in nanoseconds to seconds
+	nanoSec = 1e9
+)
+
+var (
+	volumeLabels  = []string{"pv", "namespace"}
+	replicaLabels = []string{"pv", "namespace", "replica", "mode"}
+	rebuildLabels = []string{"pv", "namespace", "replica"}
+)
+
+func newVolumeDesc(name, help string, labels []string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "volume", name), help, labels, nil)
+}
+
+// volumeCollector collects the IO stats and replica health of the jiva
+// volumes, the IO stats are fetched from the jiva controller of each
+// volume and the replica health from the JivaVolume status
+type volumeCollector struct {
+	client *client.Client
+
+	up                   *prometheus.Desc
+	readsTotal           *prometheus.Desc
+	writesTotal          *prometheus.Desc
+	readBytesTotal       *prometheus.Desc
+	writeBytesTotal      *prometheus.Desc
+	readTimeTotal        *prometheus.Desc
+	writeTimeTotal       *prometheus.Desc
+	readOnly             *prometheus.Desc
+	replicaMode          *prometheus.Desc
+	replicas             *prometheus.Desc
+	replicasHealthy      *prometheus.Desc
+	replicasRebuilding   *prometheus.Desc
+	replicasHealthyRatio *prometheus.Desc
+	rebuildProgress      *prometheus.Desc
+}
+
+func newVolumeCollector(cli *client.Client) *volumeCollector {
+	return &volumeCollector{
+		client: cli,
+		up: newVolumeDesc("up",
+			"Whether the stats of the volume could be fetched from its jiva controller.", volumeLabels),
+		readsTotal: newVolumeDesc("reads_total",
+			"Total number of read IOs served by the volume.", volumeLabels),
+		writesTotal: newVolumeDesc("writes_total",
+			"Total number of write IOs served by the volume.", volumeLabels),
+		readBytesTotal: newVolumeDesc("read_bytes_total",
+			"Total number of bytes read from the volume.", volumeLabels),
+		writeBytesTotal: newVolumeDesc("write_bytes_total",
+			"Total number of bytes written to the volume.", volumeLabels),
+		readTimeTotal: newVolumeDesc("read_time_seconds_total",
+			"Total time spent serving the read IOs of the volume.", volumeLabels),
+		writeTimeTotal: newVolumeDesc("write_time_seconds_total",
+			"Total time spent serving the write IOs of the volume.", volumeLabels),
+		readOnly: newVolumeDesc("read_only",
+			"Whether the volume is in read only (RO) mode.", volumeLabels),
+		replicaMode: newVolumeDesc("replica_mode",
+			"Mode of the replicas of the volume, 1 for the current mode of the replica.", replicaLabels),
+		replicas: newVolumeDesc("replicas",
+			"Desired number of replicas of the volume, i.e. the replication factor.", volumeLabels),
+		replicasHealthy: newVolumeDesc("replicas_healthy",
+			"Number of replicas of the volume in RW mode.", volumeLabels),
+		replicasRebuilding: newVolumeDesc("replicas_rebuilding",
+			"Number of replicas of the volume which are being rebuilt, i.e. in WO mode.", volumeLabels),
+		replicasHealthyRatio: newVolumeDesc("replicas_healthy_ratio",
+			"Ratio of the replicas in RW mode to the replication factor of the volume.", volumeLabels),
+		rebuildProgress: newVolumeDesc("replica_rebuild_progress_ratio",
+			"Ratio of the used blocks of the rebuilding replica to the used blocks of the volume.", rebuildLabels),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *volumeCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		c.up, c.readsTotal, c.writesTotal, c.readBytesTotal, c.writeBytesTotal,
+		c.readTimeTotal, c.writeTimeTotal, c.readOnly, c.replicaMode,
+		c.replicas, c.replicasHealthy, c.replicasRebuilding, c.replicasHealthyRatio,
+		c.rebuildProgress,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *volumeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+
+	list, err := c.client.ListJivaVolumeWithOpts(ctx, map[string]string{
+		"openebs.io/component": "jiva-volume",
+	})
+	if err != nil {
+		logrus.Warningf("Failed to list jiva volumes to collect metrics, err: {%v}", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := range list.Items {
+		wg.Add(1)
+		go func(instance *jv.JivaVolume) {
+			defer wg.Done()
+			c.collectVolume(ctx, ch, instance)
+		}(&list.Items[i])
+	}
+	wg.Wait()
+}
+
+func (c *volumeCollector) collectVolume(ctx context.Context, ch chan<- prometheus.Metric, instance *jv.JivaVolume) {
+	labels := []string{instance.Name, instance.Namespace}
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+	counter := func(desc *prometheus.Desc, value json.Number, scale float64) {
+		v, err := value.Float64()
+		if err != nil {
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v/scale, labels...)
+	}
+
+	var healthy, rebuilding float64
+	for _, rep := range instance.Status.ReplicaStatuses {
+		switch rep.Mode {
+		case "RW":
+			healthy++
+		case "WO":
+			rebuilding++
+		}
+		gauge(c.replicaMode, 1, instance.Name, instance.Namespace, rep.Address, rep.Mode)
+	}
+
+	rf := float64(instance.Spec.Policy.Target.ReplicationFactor)
+	gauge(c.replicas, rf, labels...)
+	gauge(c.replicasHealthy, healthy, labels...)
+	gauge(c.replicasRebuilding, rebuilding, labels...)
+	if rf != 0 {
+		gauge(c.replicasHealthyRatio, healthy/rf, labels...)
+	}
+	gauge(c.readOnly, boolToFloat(instance.Status.Status == "RO"), labels...)
+
+	stats, err := c.getStats(ctx, instance)
+	if err != nil {
+		logrus.Debugf("Failed to collect stats of volume: {%s}, err: {%v}", instance.Name, err)
+		gauge(c.up, 0, labels...)
+		return
+	}
+	gauge(c.up, 1, labels...)
+
+	counter(c.readsTotal, stats.Reads, 1)
+	counter(c.writesTotal, stats.Writes, 1)
+	counter(c.readBytesTotal, stats.TotalReadBytes, 1)
+	counter(c.writeBytesTotal, stats.TotalWriteBytes, 1)
+	counter(c.readTimeTotal, stats.TotalReadTime, nanoSec)
+	counter(c.writeTimeTotal, stats.TotalWriteTime, nanoSec)
+
+	c.collectRebuildProgress(ctx, ch, instance, stats)
+}
+
+// collectRebuildProgress collects the progress of the replicas which are
+// being rebuilt, i.e. in WO mode. Jiva syncs the used blocks of the volume
+// to the rebuilding replica, so the progress is the ratio of the used blocks
+// of the replica to the used blocks of the volume reported by the controller
+func (c *volumeCollector) collectRebuildProgress(ctx context.Context, ch chan<- prometheus.Metric,
+	instance *jv.JivaVolume, stats *volume.Stats) {
+	total, err := stats.UsedLogicalBlocks.Float64()
+	if err != nil {
+		return
+	}
+
+	for _, rep := range instance.Status.ReplicaStatuses {
+		if rep.Mode != "WO" {
+			continue
+		}
+
+		usage, err := getVolUsage(ctx, rep.Address)
+		if err != nil {
+			logrus.Debugf("Failed to collect rebuild progress of volume: {%s}, err: {%v}", instance.Name, err)
+			continue
+		}
+		used, err := usage.UsedLogicalBlocks.Float64()
+		if err != nil {
+			continue
+		}
+
+		progress := 1.0
+		if total > 0 && used < total {
+			progress = used / total
+		}
+		ch <- prometheus.MustNewConstMetric(c.rebuildProgress, prometheus.GaugeValue, progress,
+			instance.Name, instance.Namespace, rep.Address)
+	}
+}
+
+func (c *volumeCollector) getStats(ctx context.Context, instance *jv.JivaVolume) (*volume.Stats, error) {
+	cli, err := newJivaController(instance)
+	if err != nil {
+		return nil, err
+	}
+	return cli.getStats(ctx)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+)
+
+// volumeHealthyMessage is the message of the condition of a healthy volume
+const volumeHealthyMessage = "volume is healthy"
+
+// getVolumeProblems returns the problems of the volume as reported by
+// jiva-operator in the JivaVolume status, i.e. the phase and mode of
+// the target and the modes of the replicas
+func getVolumeProblems(instance *jv.JivaVolume) []string {
+	var problems []string
+	switch instance.Status.Phase {
+	case jv.JivaVolumePhaseReady:
+	case jv.JivaVolumePhaseFailed:
+		problems = append(problems, "volume is in failed phase")
+	default:
+		problems = append(problems, fmt.Sprintf("volume is not ready, phase: %s", instance.Status.Phase))
+	}
+
+	if instance.Status.Status == "RO" {
+		problems = append(problems, "volume is in read only (RO) mode")
+	}
+
+	var healthy, rebuilding, failed []string
+	for _, rep := range instance.Status.ReplicaStatuses {
+		switch rep.Mode {
+		case "RW":
+			healthy = append(healthy, rep.Address)
+		case "WO":
+			rebuilding = append(rebuilding, rep.Address)
+		default:
+			failed = append(failed, fmt.Sprintf("%s (%s)", rep.Address, rep.Mode))
+		}
+	}
+
+	rf := instance.Spec.Policy.Target.ReplicationFactor
+	if rf != 0 && len(healthy) < rf {
+		problems = append(problems, fmt.Sprintf("%d of %d replicas are healthy", len(healthy), rf))
+	}
+	if len(rebuilding) != 0 {
+		problems = append(problems, fmt.Sprintf("replicas %v are rebuilding", rebuilding))
+	}
+	if len(failed) != 0 {
+		problems = append(problems, fmt.Sprintf("replicas %v have failed", failed))
+	}
+	return problems
+}
+
+// newVolumeCondition returns the condition of the volume with the given
+// problems, the volume is abnormal if it has any problem
+func newVolumeCondition(problems []string) *csi.VolumeCondition {
+	if len(problems) == 0 {
+		return &csi.VolumeCondition{
+			Abnormal: false,
+			Message:  volumeHealthyMessage,
+		}
+	}
+	return &csi.VolumeCondition{
+		Abnormal: true,
+		Message:  strings.Join(problems, "; "),
+	}
+}
+
+// getVolumeCondition returns the condition of the volume
+// from the status of its target and replicas
+func getVolumeCondition(instance *jv.JivaVolume) *csi.VolumeCondition {
+	return newVolumeCondition(getVolumeProblems(instance))
+}
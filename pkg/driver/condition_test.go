@@ -0,0 +1,63 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+)
+
+var _ = DescribeTable("getVolumeProblems",
+	func(status jv.JivaVolumeStatus, rf int, problems []string) {
+		instance := &jv.JivaVolume{Status: status}
+		instance.Spec.Policy.Target.ReplicationFactor = rf
+		Expect(getVolumeProblems(instance)).Should(Equal(problems))
+		Expect(newVolumeCondition(problems).GetAbnormal()).Should(Equal(len(problems) != 0))
+	},
+	Entry("healthy volume", jv.JivaVolumeStatus{
+		Phase:  jv.JivaVolumePhaseReady,
+		Status: "RW",
+		ReplicaStatuses: []jv.ReplicaStatus{
+			{Address: "tcp://10.0.0.1:9502", Mode: "RW"},
+			{Address: "tcp://10.0.0.2:9502", Mode: "RW"},
+		},
+	}, 2, nil),
+	Entry("replication factor is not set", jv.JivaVolumeStatus{
+		Phase: jv.JivaVolumePhaseReady,
+	}, 0, nil),
+	Entry("failed phase", jv.JivaVolumeStatus{
+		Phase: jv.JivaVolumePhaseFailed,
+	}, 0, []string{"volume is in failed phase"}),
+	Entry("pending phase", jv.JivaVolumeStatus{
+		Phase: jv.JivaVolumePhasePending,
+	}, 0, []string{"volume is not ready, phase: Pending"}),
+	Entry("read only volume with degraded replicas", jv.JivaVolumeStatus{
+		Phase:  jv.JivaVolumePhaseReady,
+		Status: "RO",
+		ReplicaStatuses: []jv.ReplicaStatus{
+			{Address: "tcp://10.0.0.1:9502", Mode: "RW"},
+			{Address: "tcp://10.0.0.2:9502", Mode: "WO"},
+			{Address: "tcp://10.0.0.3:9502", Mode: "ERR"},
+		},
+	}, 3, []string{
+		"volume is in read only (RO) mode",
+		"1 of 3 replicas are healthy",
+		"replicas [tcp://10.0.0.2:9502] are rebuilding",
+		"replicas [tcp://10.0.0.3:9502 (ERR)] have failed",
+	}),
+)
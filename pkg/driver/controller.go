@@ -18,19 +18,23 @@ package driver
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
 	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
 	"github.com/openebs/jiva-csi/pkg/utils"
 	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
-	"github.com/openebs/jiva-operator/pkg/jiva"
 	"github.com/openebs/jiva-operator/pkg/volume"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/cloud-provider/volume/helpers"
 )
@@ -38,21 +42,40 @@ import (
 // controller is the server implementation
 // for CSI Controller
 type controller struct {
+	csi.UnimplementedControllerServer
 	client       *client.Client
+	driver       *CSIDriver
 	capabilities []*csi.ControllerServiceCapability
 }
 
 // SupportedVolumeCapabilityAccessModes contains the list of supported access
-// modes for the volume
+// modes for the volume, multi node access modes are supported only for the
+// volumes served via NFS gateway, except read only many which is also
+// supported for the existing volumes staged as read only on multiple nodes
 var SupportedVolumeCapabilityAccessModes = []*csi.VolumeCapability_AccessMode{
 	&csi.VolumeCapability_AccessMode{
 		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
 	},
+	&csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+	},
+	&csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+	},
 }
 
-var SupportedVolumeCapabilityAccessType = []*csi.VolumeCapability_Mount{
-	&csi.VolumeCapability_Mount{
-		Mount: &csi.VolumeCapability_MountVolume{},
+// SupportedVolumeCapabilityAccessType contains the list of supported access
+// types i.e. filesystem and raw block for the volume
+var SupportedVolumeCapabilityAccessType = []*csi.VolumeCapability{
+	&csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+	},
+	&csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
 	},
 }
 
@@ -63,9 +86,10 @@ var (
 
 // NewController returns a new instance
 // of CSI controller
-func NewController(cli *client.Client) csi.ControllerServer {
+func NewController(d *CSIDriver, cli *client.Client) csi.ControllerServer {
 	return &controller{
 		client:       cli,
+		driver:       d,
 		capabilities: newControllerCapabilities(),
 	}
 }
@@ -80,12 +104,23 @@ func (cs *controller) CreateVolume(
 		return nil, err
 	}
 
-	// set client each time to avoid caching issue
-	if err := cs.client.Set(); err != nil {
-		return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to set client, err: {%v}", err)
+	if isNFSGatewayEnabled(req.GetParameters()) {
+		volumeContext, err := cs.createNFSGateway(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		logrus.Infof("CreateVolume: volume: {%v} is created", req.GetName())
+		return &csi.CreateVolumeResponse{
+			Volume: &csi.Volume{
+				VolumeId:      req.GetName(),
+				CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+				VolumeContext: volumeContext,
+			},
+		}, nil
 	}
 
-	if err := cs.client.CreateJivaVolume(req); err != nil {
+	if err := cs.client.CreateJivaVolume(ctx, req); err != nil {
 		return nil, err
 	}
 
@@ -94,10 +129,25 @@ func (cs *controller) CreateVolume(
 		Volume: &csi.Volume{
 			VolumeId:      req.GetName(),
 			CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+			VolumeContext: getVolumeContext(req.GetParameters()),
 		},
 	}, nil
 }
 
+// getVolumeContext returns the context of the volume which is
+// required by node plugin to stage the volume
+func getVolumeContext(params map[string]string) map[string]string {
+	volumeContext := getISCSIVolumeContext(params)
+	if isEncryptionEnabled(params) {
+		volumeContext[encryptedParam] = "true"
+	}
+
+	if len(volumeContext) == 0 {
+		return nil
+	}
+	return volumeContext
+}
+
 // DeleteVolume deletes the specified volume
 func (cs *controller) DeleteVolume(
 	ctx context.Context,
@@ -110,12 +160,11 @@ func (cs *controller) DeleteVolume(
 		)
 	}
 	volID = strings.ToLower(volID)
-	// set client each time to avoid caching issue
-	if err := cs.client.Set(); err != nil {
-		return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to set client, err: {%v}", err)
+	if err := cs.deleteNFSGateway(ctx, volID); err != nil {
+		return nil, err
 	}
 
-	if err := cs.client.DeleteJivaVolume(volID); err != nil {
+	if err := cs.client.DeleteJivaVolume(ctx, volID); err != nil {
 		return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to delete volume {%v}, err: {%v}", req.VolumeId, err)
 	}
 
@@ -143,12 +192,7 @@ func (cs *controller) ValidateVolumeCapabilities(
 		return nil, status.Error(codes.InvalidArgument, "Volume capabilities not provided")
 	}
 
-	// set client each time to avoid caching issue
-	if err := cs.client.Set(); err != nil {
-		return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to set client, err: {%v}", err)
-	}
-
-	if _, err := cs.client.GetJivaVolume(volumeID); err != nil {
+	if _, err := cs.getJivaVolume(ctx, volumeID); err != nil {
 		return nil, err
 	}
 
@@ -176,52 +220,36 @@ func (cs *controller) ControllerGetCapabilities(
 	return resp, nil
 }
 
-func (cs *controller) isVolumeReady(volumeID string) (*jv.JivaVolume, error) {
-	var interval time.Duration = 0
-	var instance *jv.JivaVolume
-	var i int
-	for i = 0; i <= MaxRetryCount; i++ {
-		if i == MaxRetryCount {
-			return nil, status.Errorf(codes.Internal, "ExpandVolume: max retry count exceeded")
-		}
-		time.Sleep(interval * time.Second)
-		// set client each time to avoid caching issue
-		err := cs.client.Set()
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "ExpandVolume: failed to set client, err: %v", err)
-		}
-
-		instance, err = cs.client.GetJivaVolume(volumeID)
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "ExpandVolume: failed to get JivaVolume, err: %v", err)
-		}
+func (cs *controller) isVolumeReady(ctx context.Context, volumeID string) (*jv.JivaVolume, error) {
+	ctx, cancel := context.WithTimeout(ctx, getVolumeWaitTimeout())
+	defer cancel()
 
-		interval = 5
+	instance, err := cs.client.WaitForJivaVolume(ctx, volumeID, func(instance *jv.JivaVolume) (bool, error) {
 		repCount, rf := instance.Status.ReplicaCount, instance.Spec.Policy.Target.ReplicationFactor
 		if repCount != rf {
 			logrus.Warningf("All replicas are not up, RF: %v, ReplicaCount: %v", rf, repCount)
-			continue
+			return false, nil
 		}
 
 		statuses := instance.Status.ReplicaStatuses
 		if len(statuses) == 0 {
 			logrus.Warning("Replica's status is nil, volume must be initializing")
-			continue
+			return false, nil
 		}
 
 		cnt := 0
 		for _, rep := range statuses {
-			if rep.Mode == "RW" {
-				cnt++
-			} else {
-				return nil, status.Errorf(codes.Internal, "Replica: %s mode is %s", rep.Address, rep.Mode)
+			if rep.Mode != "RW" {
+				return false, status.Errorf(codes.Internal, "Replica: %s mode is %s", rep.Address, rep.Mode)
 			}
+			cnt++
 		}
-
-		desired := rf
-		if cnt == desired {
-			break
-		}
+		return cnt == rf, nil
+	})
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		return nil, status.Errorf(codes.Internal, "ExpandVolume: volume: {%v} is not ready, err: {%v}", volumeID, err)
+	} else if err != nil {
+		return nil, err
 	}
 	return instance, nil
 }
@@ -239,37 +267,27 @@ func (cs *controller) ControllerExpandVolume(
 	}
 
 	volumeID = utils.StripName(volumeID)
-	jivaVolume, err := cs.isVolumeReady(volumeID)
+	instance, err := cs.getJivaVolume(ctx, volumeID)
 	if err != nil {
 		return nil, err
 	}
 
-	updatedSize := req.GetCapacityRange().GetRequiredBytes()
-	vol := volume.Volumes{}
-	ctrlIP := jivaVolume.Spec.ISCSISpec.TargetIP
-	if len(ctrlIP) == 0 {
-		return nil, status.Errorf(codes.Internal, "Target IP is nil")
-	}
-
-	cli := jiva.NewControllerClient(jivaVolume.Spec.ISCSISpec.TargetIP + ":9501")
-	cli.SetTimeout(30 * time.Second)
-	retryCount := 0
-	var httpErr error
-	for retryCount < httpReqRetryCount {
-		httpErr = cli.Get("/volumes", &vol)
-		if httpErr == nil {
-			break
-		}
-		time.Sleep(httpReqRetryInterval)
-		retryCount++
+	// volume served via NFS gateway is expanded by
+	// expanding its backend jiva volume
+	jivaVolume, err := cs.isVolumeReady(ctx, instance.Name)
+	if err != nil {
+		return nil, err
 	}
 
-	if httpErr != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to get volume info from jiva controller, err: %v", httpErr)
+	updatedSize := req.GetCapacityRange().GetRequiredBytes()
+	cli, err := newJivaController(jivaVolume)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if len(vol.Data) == 0 {
-		return nil, status.Error(codes.Internal, "Failed to get volume info, no volume found")
+	vol, err := cli.getVolume(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	size := resource.NewQuantity(updatedSize, resource.BinarySI)
@@ -277,35 +295,35 @@ func (cs *controller) ControllerExpandVolume(
 	capacity := fmt.Sprintf("%dGi", volSizeGiB)
 
 	input := volume.ResizeInput{
-		Name: vol.Data[0].Name,
+		Name: vol.Name,
 		Size: capacity,
 	}
 
-	retryCount = 0
-	for retryCount < httpReqRetryCount {
-		httpErr = cli.Post(vol.Data[0].Actions["resize"], input, nil)
-		if httpErr == nil {
-			break
-		}
-		time.Sleep(httpReqRetryInterval)
-		retryCount++
-	}
-
-	if httpErr != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to post resize request to jiva controller, err: %v", httpErr)
+	if err := cli.doAction(ctx, "resize", input, nil, true); err != nil {
+		cs.client.WarningEventf(jivaVolume, client.ReasonResizeFailed,
+			"Failed to resize volume to %s: %v", capacity, err)
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	// set client each time to avoid caching issue
-	if err = cs.client.Set(); err != nil {
-		return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to set client, err: %v", err)
-	}
-
-	jivaVolume.Spec.Capacity = capacity
-	err = cs.client.UpdateJivaVolume(jivaVolume)
+	err = cs.client.PatchCapacity(ctx, jivaVolume, capacity)
 	if err != nil {
 		return nil, err
 	}
 
+	// filesystem of the backend is expanded on the node of NFS server,
+	// the NFS export is not expanded on the nodes which mount it
+	if isNFSBackend(jivaVolume) {
+		if err := cs.client.ResizeNFSGateway(ctx, jivaVolume.Name, jivaVolume.Namespace,
+			resource.MustParse(capacity)); err != nil {
+			return nil, status.Errorf(codes.Internal,
+				"ExpandVolume: failed to resize NFS gateway of volume: {%s}, err: {%v}", volumeID, err)
+		}
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         updatedSize,
+			NodeExpansionRequired: false,
+		}, nil
+	}
+
 	return &csi.ControllerExpandVolumeResponse{
 		CapacityBytes:         updatedSize,
 		NodeExpansionRequired: true,
@@ -320,7 +338,81 @@ func (cs *controller) CreateSnapshot(
 	req *csi.CreateSnapshotRequest,
 ) (*csi.CreateSnapshotResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	snapName := req.GetName()
+	if len(snapName) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot: snapshot name not provided")
+	}
+
+	volumeID := req.GetSourceVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot: source volume ID not provided")
+	}
+
+	volumeID = utils.StripName(volumeID)
+	snapName = strings.ToLower(snapName)
+	logrus.Infof("CreateSnapshot: creating snapshot: {%s} of volume: {%s}", snapName, volumeID)
+
+	instance, err := cs.getJivaVolume(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if instance.Status.Status != "RW" {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"CreateSnapshot: volume: {%s} is not ready, status: {%s}", volumeID, instance.Status.Status)
+	}
+
+	// snapshot names are unique across the volumes, jiva keeps the
+	// snapshots per volume so the other volumes are looked up
+	if srcVolume, err := cs.findSnapshotVolume(ctx, snapName, instance.Name); err != nil {
+		return nil, err
+	} else if srcVolume != "" {
+		return nil, status.Errorf(codes.AlreadyExists,
+			"CreateSnapshot: snapshot: {%s} already exists for volume: {%s}", snapName, srcVolume)
+	}
+
+	snap, err := createSnapshot(ctx, instance, snapName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateSnapshot: failed to create snapshot: {%s}, err: {%v}", snapName, err)
+	}
+
+	snapshot, err := newCSISnapshot(instance, snap)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	logrus.Infof("CreateSnapshot: snapshot: {%s} is created", snapshot.GetSnapshotId())
+	return &csi.CreateSnapshotResponse{
+		Snapshot: snapshot,
+	}, nil
+}
+
+// findSnapshotVolume returns the volume other than the given volume
+// which has a user created snapshot with the given name, it fails if the
+// snapshots of any volume can't be listed since the name can't be
+// verified to be unique
+func (cs *controller) findSnapshotVolume(ctx context.Context, snapName, volumeID string) (string, error) {
+	list, err := cs.client.ListJivaVolumeWithOpts(ctx, map[string]string{
+		"openebs.io/component": "jiva-volume",
+	})
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "CreateSnapshot: failed to list volumes, err: {%v}", err)
+	}
+
+	for i := range list.Items {
+		if list.Items[i].Name == volumeID {
+			continue
+		}
+		snaps, err := listSnapshots(ctx, &list.Items[i])
+		if err != nil {
+			return "", status.Errorf(codes.Unavailable,
+				"CreateSnapshot: failed to list snapshots of volume: {%s}, err: {%v}", list.Items[i].Name, err)
+		}
+		if _, ok := snaps[snapName]; ok {
+			return list.Items[i].Name, nil
+		}
+	}
+	return "", nil
 }
 
 // DeleteSnapshot deletes given snapshot
@@ -331,7 +423,35 @@ func (cs *controller) DeleteSnapshot(
 	req *csi.DeleteSnapshotRequest,
 ) (*csi.DeleteSnapshotResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	snapshotID := req.GetSnapshotId()
+	if len(snapshotID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "DeleteSnapshot: snapshot ID not provided")
+	}
+
+	volumeID, snapName, err := parseSnapshotID(snapshotID)
+	if err != nil {
+		// From the spec: If a snapshot corresponding to the specified
+		// snapshot_id does not exist or the artifacts associated with
+		// the snapshot do not exist anymore, the Plugin MUST reply 0 OK.
+		logrus.Warningf("DeleteSnapshot: %v, ignore deletion...", err)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	instance, err := cs.client.GetJivaVolume(ctx, volumeID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			logrus.Warningf("DeleteSnapshot: volume: {%s} not found, ignore deletion...", volumeID)
+			return &csi.DeleteSnapshotResponse{}, nil
+		}
+		return nil, err
+	}
+
+	if err := deleteSnapshot(ctx, instance, snapName); err != nil {
+		return nil, status.Errorf(codes.Internal, "DeleteSnapshot: failed to delete snapshot: {%s}, err: {%v}", snapshotID, err)
+	}
+
+	logrus.Infof("DeleteSnapshot: snapshot: {%s} is deleted", snapshotID)
+	return &csi.DeleteSnapshotResponse{}, nil
 }
 
 // ListSnapshots lists all snapshots for the
@@ -343,7 +463,86 @@ func (cs *controller) ListSnapshots(
 	req *csi.ListSnapshotsRequest,
 ) (*csi.ListSnapshotsResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	var (
+		volumes  []jv.JivaVolume
+		snapName string
+	)
+	volumeID := utils.StripName(req.GetSourceVolumeId())
+	if snapshotID := req.GetSnapshotId(); snapshotID != "" {
+		vol, snap, err := parseSnapshotID(snapshotID)
+		if err != nil || (volumeID != "" && vol != volumeID && vol != getNFSBackendName(volumeID)) {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		volumeID, snapName = vol, snap
+	}
+
+	if volumeID != "" {
+		instance, err := cs.getJivaVolume(ctx, volumeID)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return &csi.ListSnapshotsResponse{}, nil
+			}
+			return nil, err
+		}
+		volumes = append(volumes, *instance)
+	} else {
+		list, err := cs.client.ListJivaVolumeWithOpts(ctx, map[string]string{
+			"openebs.io/component": "jiva-volume",
+		})
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "ListSnapshots: failed to list volumes, err: {%v}", err)
+		}
+		volumes = list.Items
+	}
+
+	var entries []*csi.ListSnapshotsResponse_Entry
+	for i := range volumes {
+		snaps, err := listSnapshots(ctx, &volumes[i])
+		if err != nil {
+			logrus.Warningf("ListSnapshots: failed to list snapshots of volume: {%s}, err: {%v}", volumes[i].Name, err)
+			continue
+		}
+
+		for name, snap := range snaps {
+			if snapName != "" && name != snapName {
+				continue
+			}
+			snapshot, err := newCSISnapshot(&volumes[i], snap)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: snapshot})
+		}
+	}
+
+	// sort the entries so that the starting token
+	// points to the same entry in subsequent calls
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Snapshot.SnapshotId < entries[j].Snapshot.SnapshotId
+	})
+
+	start := 0
+	if token := req.GetStartingToken(); token != "" {
+		var err error
+		if start, err = strconv.Atoi(token); err != nil || start < 0 || start > len(entries) {
+			return nil, status.Errorf(codes.Aborted, "ListSnapshots: invalid starting token: {%s}", token)
+		}
+	}
+
+	end := len(entries)
+	if maxEntries := int(req.GetMaxEntries()); maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+	}
+
+	var nextToken string
+	if end < len(entries) {
+		nextToken = strconv.Itoa(end)
+	}
+
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries[start:end],
+		NextToken: nextToken,
+	}, nil
 }
 
 // ControllerUnpublishVolume removes a previously
@@ -354,24 +553,121 @@ func (cs *controller) ControllerUnpublishVolume(
 	ctx context.Context,
 	req *csi.ControllerUnpublishVolumeRequest,
 ) (*csi.ControllerUnpublishVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerUnpublishVolume: Volume ID not provided")
+	}
+
+	volumeID = utils.StripName(volumeID)
+	unlock, err := cs.driver.lockVolume(volumeID, "ControllerUnpublishVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-	return nil, status.Error(codes.Unimplemented, "")
+	instance, err := cs.client.GetJivaVolume(ctx, volumeID)
+	if status.Code(err) == codes.NotFound {
+		logrus.Infof("ControllerUnpublishVolume: volume: {%s} not found, ignore unpublish", volumeID)
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// volume may be already published to another node
+	nodeID, _ := getPublishedNode(instance)
+	if nodeID == "" || (req.GetNodeId() != "" && nodeID != req.GetNodeId()) {
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
+	if err := cs.client.PatchAnnotations(ctx, instance, publishedNodeAnnotations("", "")); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"ControllerUnpublishVolume: failed to revoke node: {%s} of volume: {%s}, err: {%v}", nodeID, volumeID, err)
+	}
+
+	logrus.Infof("ControllerUnpublishVolume: volume: {%s} is unpublished from node: {%s}", volumeID, nodeID)
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
 
 // ControllerPublishVolume attaches given volume
 // at the specified node
 //
+// The node and its initiator are only recorded on the JivaVolume,
+// jiva target doesn't support initiator ACLs so the logins to the
+// target are not restricted and this is not a fencing mechanism
+//
 // This implements csi.ControllerServer
 func (cs *controller) ControllerPublishVolume(
 	ctx context.Context,
 	req *csi.ControllerPublishVolumeRequest,
 ) (*csi.ControllerPublishVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: Volume ID not provided")
+	}
+
+	nodeID := req.GetNodeId()
+	if len(nodeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: Node ID not provided")
+	}
+
+	volCap := req.GetVolumeCapability()
+	if volCap == nil {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: Volume capability not provided")
+	}
+
+	if !isValidVolumeCapabilities([]*csi.VolumeCapability{volCap}) {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: Volume capability not supported")
+	}
+
+	// volumes served via NFS gateway and read only volumes are
+	// used on multiple nodes, so they are not published to a node
+	if isNFSVolume(req.GetVolumeContext()) || isMultiNodeAccessMode(volCap.GetAccessMode().GetMode()) {
+		return &csi.ControllerPublishVolumeResponse{}, nil
+	}
+
+	volumeID = utils.StripName(volumeID)
+	unlock, err := cs.driver.lockVolume(volumeID, "ControllerPublishVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	instance, err := cs.client.GetJivaVolume(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := cs.client.GetNode(ctx, nodeID)
+	if errors.IsNotFound(err) {
+		return nil, status.Errorf(codes.NotFound, "ControllerPublishVolume: node: {%s} not found", nodeID)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerPublishVolume: failed to get node: {%s}, err: {%v}", nodeID, err)
+	}
+	iqn := node.Annotations[jivavolume.NodeInitiatorIQNKey]
+
+	publishedNode, publishedIQN := getPublishedNode(instance)
+	if publishedNode != "" && publishedNode != nodeID {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"ControllerPublishVolume: volume: {%s} is already published to node: {%s}", volumeID, publishedNode)
+	}
 
-	return nil, status.Error(codes.Unimplemented, "")
+	if publishedNode != nodeID || publishedIQN != iqn {
+		if err := cs.client.PatchAnnotations(ctx, instance, publishedNodeAnnotations(nodeID, iqn)); err != nil {
+			return nil, status.Errorf(codes.Internal,
+				"ControllerPublishVolume: failed to publish volume: {%s} to node: {%s}, err: {%v}", volumeID, nodeID, err)
+		}
+	}
+
+	logrus.Infof("ControllerPublishVolume: volume: {%s} is published to node: {%s}, initiator: {%s}", volumeID, nodeID, iqn)
+	return &csi.ControllerPublishVolumeResponse{
+		PublishContext: map[string]string{
+			initiatorIQNKey: iqn,
+		},
+	}, nil
 }
 
-// GetCapacity return the capacity of the
-// given volume
+// GetCapacity returns the capacity available for the volumes
+// of the given storage class parameters
 //
 // This implements csi.ControllerServer
 func (cs *controller) GetCapacity(
@@ -379,7 +675,29 @@ func (cs *controller) GetCapacity(
 	req *csi.GetCapacityRequest,
 ) (*csi.GetCapacityResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	if volCaps := req.GetVolumeCapabilities(); len(volCaps) != 0 && !isValidVolumeCapabilities(volCaps) {
+		return &csi.GetCapacityResponse{}, nil
+	}
+
+	params := req.GetParameters()
+	policy, err := cs.client.GetJivaVolumePolicySpec(ctx, params["policy"], client.GetNamespace(params))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "GetCapacity: failed to get policy: {%s}, err: {%v}", params["policy"], err)
+	}
+
+	free, err := getFreeCapacity(ctx, cs.client, policy)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "GetCapacity: failed to get free capacity of replica storage class: {%s}, err: {%v}",
+			policy.ReplicaSC, err)
+	}
+
+	capacity := getAvailableCapacity(free, policy.Target.ReplicationFactor)
+	logrus.Debugf("GetCapacity: replicaSC: {%s}, RF: {%d}, free capacity per node: {%v}, available capacity: {%d}",
+		policy.ReplicaSC, policy.Target.ReplicationFactor, free, capacity)
+
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: capacity,
+	}, nil
 }
 
 // ListVolumes lists all the volumes
@@ -390,7 +708,136 @@ func (cs *controller) ListVolumes(
 	req *csi.ListVolumesRequest,
 ) (*csi.ListVolumesResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	list, err := cs.client.ListJivaVolumeWithOpts(ctx, map[string]string{
+		"openebs.io/component": "jiva-volume",
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "ListVolumes: failed to list volumes, err: {%v}", err)
+	}
+
+	volumes, nextToken := pageVolumes(list.Items, req.GetStartingToken(), int(req.GetMaxEntries()))
+	var entries []*csi.ListVolumesResponse_Entry
+	for i := range volumes {
+		entry, err := newListVolumesEntry(&volumes[i])
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "ListVolumes: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return &csi.ListVolumesResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
+
+// pageVolumes returns the page of the given volumes which starts at the
+// starting token, and the token of the next page. Volumes are sorted by
+// name and the token is the name of the next volume to be listed, so that
+// the token remains valid even if volumes are created or deleted between
+// subsequent calls
+func pageVolumes(volumes []jv.JivaVolume, startingToken string, maxEntries int) ([]jv.JivaVolume, string) {
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+
+	start := sort.Search(len(volumes), func(i int) bool {
+		return volumes[i].Name >= startingToken
+	})
+
+	end := len(volumes)
+	if maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+	}
+
+	var nextToken string
+	if end < len(volumes) {
+		nextToken = volumes[end].Name
+	}
+	return volumes[start:end], nextToken
+}
+
+// ControllerGetVolume returns the capacity, the published node
+// and the condition of the given volume
+//
+// This implements csi.ControllerServer
+func (cs *controller) ControllerGetVolume(
+	ctx context.Context,
+	req *csi.ControllerGetVolumeRequest,
+) (*csi.ControllerGetVolumeResponse, error) {
+	volID := req.GetVolumeId()
+	if volID == "" {
+		return nil, status.Error(codes.InvalidArgument, "ControllerGetVolume: missing volume id")
+	}
+
+	instance, err := cs.getJivaVolume(ctx, strings.ToLower(volID))
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := newListVolumesEntry(instance)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerGetVolume: %v", err)
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: entry.Volume,
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: entry.Status.PublishedNodeIds,
+			VolumeCondition:  entry.Status.VolumeCondition,
+		},
+	}, nil
+}
+
+// newListVolumesEntry converts the given JivaVolume into ListVolumes entry,
+// the node on which the volume is published is fetched from the nodeID label
+// which is set during NodeStageVolume, read only volumes are published on
+// the nodes of their read only attachments. The condition of the volume is
+// fetched from the JivaVolume status. Backend of an NFS gateway is listed
+// as the volume served by the gateway, which is not published to any node
+func newListVolumesEntry(instance *jv.JivaVolume) (*csi.ListVolumesResponse_Entry, error) {
+	var capacityBytes int64
+	if instance.Spec.Capacity != "" {
+		capacity, err := resource.ParseQuantity(instance.Spec.Capacity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse capacity of volume: {%s}, err: {%v}", instance.Name, err)
+		}
+		capacityBytes = capacity.Value()
+	}
+
+	if volumeID := instance.Annotations[jivavolume.NFSGatewayVolumeKey]; volumeID != "" {
+		return &csi.ListVolumesResponse_Entry{
+			Volume: &csi.Volume{
+				VolumeId:      volumeID,
+				CapacityBytes: capacityBytes,
+			},
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				VolumeCondition: getVolumeCondition(instance),
+			},
+		}, nil
+	}
+
+	var publishedNodeIDs []string
+	if nodeID, _ := getPublishedNode(instance); nodeID != "" {
+		publishedNodeIDs = append(publishedNodeIDs, nodeID)
+	} else if nodeID := instance.Labels["nodeID"]; nodeID != "" {
+		publishedNodeIDs = append(publishedNodeIDs, nodeID)
+	}
+	for nodeID := range getReadOnlyAttachments(instance) {
+		publishedNodeIDs = append(publishedNodeIDs, nodeID)
+	}
+	sort.Strings(publishedNodeIDs)
+
+	return &csi.ListVolumesResponse_Entry{
+		Volume: &csi.Volume{
+			VolumeId:      instance.Name,
+			CapacityBytes: capacityBytes,
+		},
+		Status: &csi.ListVolumesResponse_VolumeStatus{
+			PublishedNodeIds: publishedNodeIDs,
+			VolumeCondition:  getVolumeCondition(instance),
+		},
+	}, nil
 }
 
 // IsSupportedVolumeCapabilityAccessMode valides the requested access mode
@@ -425,6 +872,14 @@ func newControllerCapabilities() []*csi.ControllerServiceCapability {
 	for _, cap := range []csi.ControllerServiceCapability_RPC_Type{
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
 	} {
 		capabilities = append(capabilities, fromType(cap))
 	}
@@ -432,7 +887,23 @@ func newControllerCapabilities() []*csi.ControllerServiceCapability {
 }
 
 func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
+	hasAccessType := func(cap *csi.VolumeCapability) bool {
+		// access type is optional in ValidateVolumeCapabilities
+		if cap.GetAccessType() == nil {
+			return true
+		}
+		for _, c := range SupportedVolumeCapabilityAccessType {
+			if reflect.TypeOf(c.GetAccessType()) == reflect.TypeOf(cap.GetAccessType()) {
+				return true
+			}
+		}
+		return false
+	}
+
 	hasSupport := func(cap *csi.VolumeCapability) bool {
+		if !hasAccessType(cap) {
+			return false
+		}
 		for _, c := range SupportedVolumeCapabilityAccessModes {
 			if c.GetMode() == cap.AccessMode.GetMode() {
 				return true
@@ -472,5 +943,56 @@ func (cs *controller) validateVolumeCreateReq(req *csi.CreateVolumeRequest) erro
 			"Failed to validate volume capabilities")
 	}
 
+	if err := validateCHAPSecrets(req.GetSecrets()); err != nil {
+		return status.Errorf(codes.InvalidArgument, "Failed to validate volume create request: %v", err)
+	}
+
+	// jiva-operator can't seed the replicas of a new volume, so the
+	// request is rejected instead of provisioning a blank volume
+	if snap := req.GetVolumeContentSource().GetSnapshot(); snap != nil {
+		return status.Errorf(
+			codes.Unimplemented,
+			"Failed to validate volume create request: restore from snapshot: {%s} is not supported", snap.GetSnapshotId())
+	}
+
+	if vol := req.GetVolumeContentSource().GetVolume(); vol != nil {
+		return status.Errorf(
+			codes.Unimplemented,
+			"Failed to validate volume create request: clone of volume: {%s} is not supported", vol.GetVolumeId())
+	}
+
+	nfsGateway := isNFSGatewayEnabled(req.GetParameters())
+	for _, volCap := range volCapabilities {
+		if nfsGateway && volCap.GetBlock() != nil {
+			return status.Error(
+				codes.InvalidArgument,
+				"Failed to validate volume capabilities: block volumes can't be served via NFS gateway")
+		}
+
+		if !nfsGateway && isMultiNodeAccessMode(volCap.GetAccessMode().GetMode()) {
+			return status.Errorf(
+				codes.InvalidArgument,
+				"Failed to validate volume capabilities: access mode: {%s} requires parameter: {%s: \"true\"}",
+				volCap.GetAccessMode().GetMode(), nfsGatewayParam)
+		}
+	}
+
+	if _, ok := req.GetParameters()[nfsServerImageParam]; ok {
+		return status.Errorf(
+			codes.InvalidArgument,
+			"Failed to validate volume create request: parameter: {%s} is not supported, NFS server image is set by the controller plugin",
+			nfsServerImageParam)
+	}
+
+	if err := validateISCSIParams(req.GetParameters()); err != nil {
+		return status.Errorf(codes.InvalidArgument, "Failed to validate volume create request: %v", err)
+	}
+
+	if nfsGateway && isEncryptionEnabled(req.GetParameters()) {
+		return status.Error(
+			codes.InvalidArgument,
+			"Failed to validate volume create request: encryption is not supported with NFS gateway")
+	}
+
 	return nil
 }
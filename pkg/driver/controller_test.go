@@ -0,0 +1,77 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newJivaVolumes(names ...string) []jv.JivaVolume {
+	volumes := make([]jv.JivaVolume, 0, len(names))
+	for _, name := range names {
+		volumes = append(volumes, jv.JivaVolume{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	return volumes
+}
+
+var _ = DescribeTable("pageVolumes",
+	func(volumes []string, startingToken string, maxEntries int, page []string, nextToken string) {
+		paged, next := pageVolumes(newJivaVolumes(volumes...), startingToken, maxEntries)
+		names := []string{}
+		for _, vol := range paged {
+			names = append(names, vol.Name)
+		}
+		Expect(names).Should(Equal(page))
+		Expect(next).Should(Equal(nextToken))
+	},
+	Entry("no volumes", nil, "", 0, []string{}, ""),
+	Entry("all volumes sorted by name", []string{"pvc-c", "pvc-a", "pvc-b"}, "", 0,
+		[]string{"pvc-a", "pvc-b", "pvc-c"}, ""),
+	Entry("first page", []string{"pvc-c", "pvc-a", "pvc-b"}, "", 2,
+		[]string{"pvc-a", "pvc-b"}, "pvc-c"),
+	Entry("last page", []string{"pvc-c", "pvc-a", "pvc-b"}, "pvc-c", 2,
+		[]string{"pvc-c"}, ""),
+	Entry("max entries equal to the remaining volumes", []string{"pvc-a", "pvc-b"}, "", 2,
+		[]string{"pvc-a", "pvc-b"}, ""),
+	Entry("token of a deleted volume starts at the next volume", []string{"pvc-a", "pvc-c", "pvc-d"}, "pvc-b", 1,
+		[]string{"pvc-c"}, "pvc-d"),
+	Entry("token after the last volume", []string{"pvc-a", "pvc-b"}, "pvc-z", 1,
+		[]string{}, ""),
+)
+
+var _ = DescribeTable("newListVolumesEntry",
+	func(name string, annotations, labels map[string]string, volumeID string, nodes []string) {
+		instance := &jv.JivaVolume{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: annotations,
+			Labels:      labels,
+		}}
+		entry, err := newListVolumesEntry(instance)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entry.GetVolume().GetVolumeId()).Should(Equal(volumeID))
+		Expect(entry.GetStatus().GetPublishedNodeIds()).Should(Equal(nodes))
+	},
+	Entry("volume staged on a node", "pvc-1", nil,
+		map[string]string{"nodeID": "node-1"}, "pvc-1", []string{"node-1"}),
+	Entry("backend of NFS gateway is listed as the gateway volume", "nfs-1",
+		map[string]string{jivavolume.NFSGatewayVolumeKey: "pvc-1"},
+		map[string]string{"nodeID": "node-1"}, "pvc-1", nil),
+)
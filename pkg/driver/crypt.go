@@ -0,0 +1,164 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// encryptedParam is the storage class parameter which enables
+	// the encryption at rest of the volume using dm-crypt/LUKS
+	encryptedParam = "encrypted"
+
+	// encryptionPassphraseKey is the node stage secret key
+	// of the passphrase of the LUKS device
+	encryptionPassphraseKey = "encryptionPassphrase"
+
+	cryptMapperDir = "/dev/mapper"
+)
+
+// isEncryptionEnabled checks if the encryption is enabled
+// in the given parameters or volume context
+func isEncryptionEnabled(params map[string]string) bool {
+	return strings.ToLower(params[encryptedParam]) == "true"
+}
+
+// getCryptMappingName returns the name of the dm-crypt
+// mapping of the given volume
+func getCryptMappingName(volumeID string) string {
+	return volumeID + "-luks"
+}
+
+// getCryptMappingPath returns the device path of the
+// dm-crypt mapping of the given volume
+func getCryptMappingPath(volumeID string) string {
+	return filepath.Join(cryptMapperDir, getCryptMappingName(volumeID))
+}
+
+// isCryptMappingOpen checks if the dm-crypt mapping
+// of the given volume exists on the node
+func isCryptMappingOpen(volumeID string) (bool, error) {
+	_, err := os.Stat(getCryptMappingPath(volumeID))
+	if err == nil {
+		return true, nil
+	} else if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// isLUKSDevice checks if the given device has a LUKS header
+func (ns *node) isLUKSDevice(devicePath string) (bool, error) {
+	out, err := ns.mounter.Exec.Command("cryptsetup", "isLuks", devicePath).CombinedOutput()
+	if err == nil {
+		return true, nil
+	}
+	// isLuks exits with status 1 if the device is not LUKS
+	if exitErr, ok := err.(utilexec.ExitError); ok && exitErr.ExitStatus() == 1 {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check LUKS header of device: {%s}, err: {%v}, output: {%s}", devicePath, err, string(out))
+}
+
+// cryptsetup runs the cryptsetup command with the
+// passphrase passed via stdin
+func (ns *node) cryptsetup(passphrase string, args ...string) error {
+	cmd := ns.mounter.Exec.Command("cryptsetup", append(args, "--key-file=-")...)
+	cmd.SetStdin(strings.NewReader(passphrase))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cryptsetup %s failed, err: {%v}, output: {%s}", args[0], err, string(out))
+	}
+	return nil
+}
+
+// openEncryptedDevice opens the dm-crypt mapping of the volume on the given
+// device and returns the path of the mapping. The device is formatted as
+// LUKS on first use, devices which already have any other data or are
+// staged as read only are never formatted
+func (ns *node) openEncryptedDevice(volumeID, devicePath, passphrase string, readOnly bool) (string, error) {
+	mappingPath := getCryptMappingPath(volumeID)
+	open, err := isCryptMappingOpen(volumeID)
+	if err != nil {
+		return "", err
+	}
+	if open {
+		logrus.Infof("NodeStageVolume: crypt mapping: {%s} of volume: {%s} is already open", mappingPath, volumeID)
+		return mappingPath, nil
+	}
+
+	isLUKS, err := ns.isLUKSDevice(devicePath)
+	if err != nil {
+		return "", err
+	}
+
+	if !isLUKS {
+		if readOnly {
+			return "", fmt.Errorf("refusing to format read only device: {%s} of volume: {%s} as LUKS", devicePath, volumeID)
+		}
+
+		existingFormat, err := ns.mounter.GetDiskFormat(devicePath)
+		if err != nil {
+			return "", err
+		}
+		if existingFormat != "" {
+			return "", fmt.Errorf("refusing to format device: {%s} of volume: {%s} as LUKS, it has existing format: {%s}",
+				devicePath, volumeID, existingFormat)
+		}
+
+		// LUKS1 is used since the mapping can be resized
+		// without the passphrase during volume expansion
+		logrus.Infof("NodeStageVolume: formatting device: {%s} of volume: {%s} as LUKS", devicePath, volumeID)
+		if err := ns.cryptsetup(passphrase, "luksFormat", "-q", "--type", "luks1", devicePath); err != nil {
+			return "", err
+		}
+	}
+
+	args := []string{"luksOpen", devicePath, getCryptMappingName(volumeID)}
+	if readOnly {
+		args = append(args, "--readonly")
+	}
+
+	logrus.Infof("NodeStageVolume: opening crypt mapping: {%s} of volume: {%s}", mappingPath, volumeID)
+	if err := ns.cryptsetup(passphrase, args...); err != nil {
+		return "", err
+	}
+	return mappingPath, nil
+}
+
+// closeEncryptedDevice closes the dm-crypt mapping of the
+// volume, it is a no-op if the mapping doesn't exist
+func (ns *node) closeEncryptedDevice(volumeID string) error {
+	open, err := isCryptMappingOpen(volumeID)
+	if err != nil || !open {
+		return err
+	}
+
+	logrus.Infof("NodeUnstageVolume: closing crypt mapping of volume: {%s}", volumeID)
+	out, err := ns.mounter.Exec.Command("cryptsetup", "luksClose", getCryptMappingName(volumeID)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to close crypt mapping of volume: {%s}, err: {%v}, output: {%s}", volumeID, err, string(out))
+	}
+	return nil
+}
@@ -18,11 +18,30 @@ package driver
 
 import (
 	"os"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	config "github.com/openebs/jiva-csi/pkg/config"
 	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/openebs/jiva-csi/pkg/request"
+	"github.com/openebs/jiva-csi/pkg/utils"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// owners of the volume operations
+	lockOwnerRPC           = "CO"
+	lockOwnerMonitorMounts = "MonitorMounts"
+
+	// stuckOperationTimeout is the duration after which
+	// an operation in progress on a volume is reported
+	// as stuck, stuckOperationInterval is the interval
+	// at which the operations are verified
+	stuckOperationTimeout  = 5 * time.Minute
+	stuckOperationInterval = time.Minute
 )
 
 // volume can only be published once as
@@ -42,6 +61,13 @@ type CSIDriver struct {
 	ns     csi.NodeServer
 	cs     csi.ControllerServer
 
+	// readiness reports whether the plugin
+	// is ready to serve the requests
+	readiness *readiness
+
+	// locks serializes the operations on each volume
+	locks *request.VolumeLocks
+
 	cap []*csi.VolumeCapability_AccessMode
 }
 
@@ -50,6 +76,8 @@ type CSIDriver struct {
 func GetVolumeCapabilityAccessModes() []*csi.VolumeCapability_AccessMode {
 	supported := []csi.VolumeCapability_AccessMode_Mode{
 		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
 	}
 
 	var vcams []*csi.VolumeCapability_AccessMode
@@ -68,23 +96,32 @@ func newVolumeCapabilityAccessMode(mode csi.VolumeCapability_AccessMode_Mode) *c
 func New(config *config.Config, cli *client.Client) *CSIDriver {
 	driver := &CSIDriver{
 		config: config,
+		locks:  request.NewVolumeLocks(),
 		cap:    GetVolumeCapabilityAccessModes(),
 	}
 
 	switch config.PluginType {
 	case "controller":
-		driver.cs = NewController(cli)
+		driver.cs = NewController(driver, cli)
+		// metrics of all the volumes are collected by the
+		// controller plugin, so that they are not duplicated
+		metrics.Registry.MustRegister(newVolumeCollector(cli))
+		driver.readiness = newReadiness(checkJivaVolumeAccess(cli))
 
 	case "node":
 		ns := NewNode(driver, cli)
+		checks := []readinessCheck{checkNodeCommands(ns.mounter.Exec)}
 		remount := os.Getenv("REMOUNT")
 		if remount == "true" || remount == "True" {
 			nm := newNodeMounterWithOpts(
 				withClient(cli),
-				withNodeID(config.NodeID))
+				withNodeID(config.NodeID),
+				withLocks(driver.locks))
 			go nm.MonitorMounts()
+			checks = append(checks, checkMonitorMounts(nm))
 		}
 		driver.ns = ns
+		driver.readiness = newReadiness(checks...)
 	}
 
 	// Identity server is common to both node and
@@ -92,9 +129,38 @@ func New(config *config.Config, cli *client.Client) *CSIDriver {
 	// share capabilities and probe the corresponding
 	// driver
 	driver.ids = NewIdentity(driver)
+	go driver.monitorOperations()
 	return driver
 }
 
+// lockVolume locks the volume for the given RPC, it returns
+// Aborted with the operation in progress if the volume is busy.
+// The volume is locked by its stripped name, which is also the
+// name of the JivaVolume locked by Remount, so that all the
+// operations on a volume use the same key
+func (d *CSIDriver) lockVolume(volumeID, rpc string) (func(), error) {
+	unlock, err := d.locks.TryLock(utils.StripName(volumeID), rpc, lockOwnerRPC)
+	if err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	return unlock, nil
+}
+
+// monitorOperations periodically reports the operations
+// which are in progress for longer than stuckOperationTimeout
+// This function runs a never ending loop therefore should be
+// run as a goroutine
+func (d *CSIDriver) monitorOperations() {
+	ticker := time.NewTicker(stuckOperationInterval)
+	for range ticker.C {
+		stuck := d.locks.Stuck(stuckOperationTimeout)
+		volumeOperationsStuck.Set(float64(len(stuck)))
+		for _, op := range stuck {
+			logrus.Warningf("Operation: {%s} on volume: {%s} looks stuck", op, op.VolumeID)
+		}
+	}
+}
+
 // Run starts the CSI plugin by communicating
 // over the given endpoint
 func (d *CSIDriver) Run() error {
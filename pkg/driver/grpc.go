@@ -145,7 +145,7 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 	}
 
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
+		grpc.UnaryInterceptor(chainUnaryInterceptors(metricsGRPC, logGRPC)),
 	}
 	// Create a new grpc server, all the request from csi client to
 	// create/delete/... will hit this server
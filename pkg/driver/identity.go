@@ -19,14 +19,17 @@ package driver
 import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/openebs/jiva-csi/version"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 // identity is the server implementation
 // for CSI IdentityServer
 type identity struct {
+	csi.UnimplementedIdentityServer
 	driver *CSIDriver
 }
 
@@ -64,10 +67,11 @@ func (id *identity) GetPluginInfo(
 	}, nil
 }
 
-// TODO
-// Need to implement this
-//
-// Probe checks if the plugin is running or not
+// Probe checks if the plugin is ready to serve the requests, the
+// controller plugin must be able to list JivaVolumes, and the node
+// plugin must be able to run iscsiadm and mount without MonitorMounts
+// being stalled. Ready is false if the plugin is not ready, the
+// response has no field for the reason so it is logged instead
 //
 // This implements csi.IdentityServer
 func (id *identity) Probe(
@@ -75,7 +79,18 @@ func (id *identity) Probe(
 	req *csi.ProbeRequest,
 ) (*csi.ProbeResponse, error) {
 
-	return &csi.ProbeResponse{}, nil
+	if id.driver.readiness != nil {
+		if err := id.driver.readiness.check(); err != nil {
+			logrus.Debugf("Probe: plugin is not ready, reason: {%v}", err)
+			return &csi.ProbeResponse{
+				Ready: wrapperspb.Bool(false),
+			}, nil
+		}
+	}
+
+	return &csi.ProbeResponse{
+		Ready: wrapperspb.Bool(true),
+	}, nil
 }
 
 // GetPluginCapabilities returns supported capabilities
@@ -0,0 +1,249 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kubernetes-csi/csi-lib-iscsi/iscsi"
+	"github.com/openebs/jiva-csi/pkg/config"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// storage class parameters which override the iSCSI
+	// config of the node plugin for the volume
+	iscsiInterfaceParam     = "iscsiInterface"
+	replacementTimeoutParam = "replacementTimeout"
+	noopOutIntervalParam    = "noopOutInterval"
+	noopOutTimeoutParam     = "noopOutTimeout"
+	queueDepthParam         = "queueDepth"
+)
+
+// iscsiSessionParams maps the storage class parameters to the
+// iscsiadm node record settings
+var iscsiSessionParams = []struct {
+	param   string
+	setting string
+}{
+	{replacementTimeoutParam, "node.session.timeo.replacement_timeout"},
+	{noopOutIntervalParam, "node.conn[0].timeo.noop_out_interval"},
+	{noopOutTimeoutParam, "node.conn[0].timeo.noop_out_timeout"},
+	{queueDepthParam, "node.session.queue_depth"},
+}
+
+// iscsiParams are the iSCSI interface and session parameters of a volume,
+// the session parameters which are not set are left at iscsiadm defaults
+type iscsiParams struct {
+	iface    string
+	settings map[string]string
+}
+
+// getISCSIConfigParams converts the iSCSI config of the node
+// plugin into the parameters of the storage class
+func getISCSIConfigParams(cfg config.ISCSIConfig) map[string]string {
+	params := map[string]string{}
+	if cfg.Interface != "" {
+		params[iscsiInterfaceParam] = cfg.Interface
+	}
+
+	for param, val := range map[string]int{
+		replacementTimeoutParam: cfg.ReplacementTimeout,
+		noopOutIntervalParam:    cfg.NoopOutInterval,
+		noopOutTimeoutParam:     cfg.NoopOutTimeout,
+		queueDepthParam:         cfg.QueueDepth,
+	} {
+		if val > 0 {
+			params[param] = strconv.Itoa(val)
+		}
+	}
+	return params
+}
+
+// validateISCSIParams checks that the iSCSI session parameters
+// of the given storage class parameters are valid
+func validateISCSIParams(params map[string]string) error {
+	for _, p := range iscsiSessionParams {
+		val, ok := params[p.param]
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(val); err != nil || n < 0 {
+			return fmt.Errorf("invalid value: {%s} of parameter: {%s}, must be a non negative integer", val, p.param)
+		}
+	}
+	return nil
+}
+
+// getISCSIParams returns the iSCSI parameters of the volume, the
+// parameters in the volume context override the node plugin config
+func getISCSIParams(cfg config.ISCSIConfig, volumeContext map[string]string) (iscsiParams, error) {
+	params := getISCSIConfigParams(cfg)
+	for _, key := range append([]string{iscsiInterfaceParam}, getISCSISessionParamKeys()...) {
+		if val, ok := volumeContext[key]; ok && val != "" {
+			params[key] = val
+		}
+	}
+
+	if err := validateISCSIParams(params); err != nil {
+		return iscsiParams{}, err
+	}
+
+	p := iscsiParams{
+		iface:    params[iscsiInterfaceParam],
+		settings: map[string]string{},
+	}
+	if p.iface == "" {
+		p.iface = defaultISCSIInterface
+	}
+	for _, sp := range iscsiSessionParams {
+		if val, ok := params[sp.param]; ok {
+			p.settings[sp.setting] = val
+		}
+	}
+	return p, nil
+}
+
+// getISCSISessionParamKeys returns the storage class
+// parameter keys of the iSCSI session parameters
+func getISCSISessionParamKeys() []string {
+	var keys []string
+	for _, p := range iscsiSessionParams {
+		keys = append(keys, p.param)
+	}
+	return keys
+}
+
+// getISCSIVolumeContext returns the iSCSI parameters of the storage
+// class which are passed to node plugin via volume context
+func getISCSIVolumeContext(params map[string]string) map[string]string {
+	volumeContext := map[string]string{}
+	for _, key := range append([]string{iscsiInterfaceParam}, getISCSISessionParamKeys()...) {
+		if val, ok := params[key]; ok && val != "" {
+			volumeContext[key] = val
+		}
+	}
+	return volumeContext
+}
+
+// configureISCSINode creates the node records of the target and applies
+// the session parameters to them before login. iscsi lib does discovery
+// and login in a single call, so the records are created here the same
+// way as iscsi lib does and discovery is disabled in the connector
+func configureISCSINode(ctx context.Context, exec utilexec.Interface, connector *iscsi.Connector, params iscsiParams) error {
+	if len(params.settings) == 0 {
+		return nil
+	}
+
+	for _, portal := range connector.TargetPortals {
+		// iscsi lib doesn't take a context, so the
+		// request is checked before each portal
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if connector.DoDiscovery {
+			if err := iscsi.Discovery(portal, connector.Interface, connector.DiscoverySecrets, connector.DoCHAPDiscovery); err != nil {
+				return err
+			}
+		}
+
+		if err := iscsi.CreateDBEntry(connector.TargetIqn, portal, connector.Interface,
+			connector.DiscoverySecrets, connector.SessionSecrets, connector.DoCHAPDiscovery); err != nil {
+			return err
+		}
+
+		args := []string{"-m", "node", "-T", connector.TargetIqn, "-p", portal, "-I", connector.Interface, "-o", "update"}
+		for setting, val := range params.settings {
+			args = append(args, "-n", setting, "-v", val)
+		}
+
+		out, err := exec.CommandContext(ctx, "iscsiadm", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to update session params of target: {%s}, portal: {%s}, err: {%v}, output: {%s}",
+				connector.TargetIqn, portal, err, string(out))
+		}
+	}
+
+	connector.DoDiscovery = false
+	connector.DoCHAPDiscovery = false
+	logrus.Infof("NodeStageVolume: target: {%s} is configured with iface: {%s}, session params: {%v}",
+		connector.TargetIqn, connector.Interface, params.settings)
+	return nil
+}
+
+// getISCSIIface returns the settings of the given iscsiadm iface, the
+// settings which are not set are skipped
+func getISCSIIface(ctx context.Context, exec utilexec.Interface, iface string) (map[string]string, error) {
+	out, err := exec.CommandContext(ctx, "iscsiadm", "-m", "iface", "-I", iface, "-o", "show").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get iface: {%s}, err: {%v}, output: {%s}", iface, err, string(out))
+	}
+
+	// settings are of the format:
+	// iface.net_ifacename = eth1
+	settings := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		key, val := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if !strings.HasPrefix(key, "iface.") || key == "iface.iscsi_ifacename" ||
+			val == "" || val == "<empty>" {
+			continue
+		}
+		settings[key] = val
+	}
+	return settings, nil
+}
+
+// restoreISCSIIface recreates the given iscsiadm iface with the given
+// settings if it doesn't exist. iscsi lib deletes the iface of the
+// connector if login fails on all the portals, so a custom iface must
+// be restored, the default iface is builtin and is never deleted
+func restoreISCSIIface(ctx context.Context, exec utilexec.Interface, iface string, settings map[string]string) error {
+	if iface == defaultISCSIInterface {
+		return nil
+	}
+
+	if _, err := getISCSIIface(ctx, exec, iface); err == nil {
+		return nil
+	}
+
+	out, err := exec.CommandContext(ctx, "iscsiadm", "-m", "iface", "-I", iface, "-o", "new").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to create iface: {%s}, err: {%v}, output: {%s}", iface, err, string(out))
+	}
+
+	for key, val := range settings {
+		out, err := exec.CommandContext(ctx, "iscsiadm", "-m", "iface", "-I", iface,
+			"-o", "update", "-n", key, "-v", val).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to update setting: {%s} of iface: {%s}, err: {%v}, output: {%s}",
+				key, iface, err, string(out))
+		}
+	}
+
+	logrus.Warningf("NodeStageVolume: iface: {%s} deleted by failed login is restored", iface)
+	return nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/openebs/jiva-csi/pkg/config"
+)
+
+var _ = DescribeTable("validateISCSIParams",
+	func(params map[string]string, valid bool) {
+		err := validateISCSIParams(params)
+		if valid {
+			Expect(err).ShouldNot(HaveOccurred())
+		} else {
+			Expect(err).Should(HaveOccurred())
+		}
+	},
+	Entry("no params", nil, true),
+	Entry("valid session params", map[string]string{
+		replacementTimeoutParam: "30",
+		noopOutIntervalParam:    "5",
+		noopOutTimeoutParam:     "0",
+		queueDepthParam:         "128",
+	}, true),
+	Entry("interface is not a session param", map[string]string{iscsiInterfaceParam: "eth1"}, true),
+	Entry("non integer value", map[string]string{replacementTimeoutParam: "30s"}, false),
+	Entry("negative value", map[string]string{queueDepthParam: "-1"}, false),
+)
+
+var _ = DescribeTable("getISCSIParams",
+	func(cfg config.ISCSIConfig, volumeContext map[string]string, expected iscsiParams, valid bool) {
+		params, err := getISCSIParams(cfg, volumeContext)
+		if !valid {
+			Expect(err).Should(HaveOccurred())
+			return
+		}
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(params).Should(Equal(expected))
+	},
+	Entry("defaults", config.ISCSIConfig{}, nil,
+		iscsiParams{iface: defaultISCSIInterface, settings: map[string]string{}}, true),
+	Entry("node plugin config", config.ISCSIConfig{
+		Interface:          "iface0",
+		ReplacementTimeout: 30,
+		QueueDepth:         64,
+	}, nil, iscsiParams{iface: "iface0", settings: map[string]string{
+		"node.session.timeo.replacement_timeout": "30",
+		"node.session.queue_depth":               "64",
+	}}, true),
+	Entry("volume context overrides the config", config.ISCSIConfig{
+		Interface:          "iface0",
+		ReplacementTimeout: 30,
+	}, map[string]string{
+		iscsiInterfaceParam:     "iface1",
+		replacementTimeoutParam: "120",
+		noopOutIntervalParam:    "5",
+		noopOutTimeoutParam:     "",
+	}, iscsiParams{iface: "iface1", settings: map[string]string{
+		"node.session.timeo.replacement_timeout": "120",
+		"node.conn[0].timeo.noop_out_interval":   "5",
+	}}, true),
+	Entry("invalid volume context", config.ISCSIConfig{},
+		map[string]string{noopOutTimeoutParam: "abc"}, iscsiParams{}, false),
+)
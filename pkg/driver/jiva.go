@@ -0,0 +1,223 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/openebs/jiva-operator/pkg/volume"
+	"golang.org/x/net/context"
+)
+
+const (
+	// jivaControllerPort is the port on which jiva controller
+	// serves the REST API
+	jivaControllerPort = "9501"
+
+	// jivaRequestTimeout is the timeout of each request
+	// made to the jiva controller and replicas
+	jivaRequestTimeout = 30 * time.Second
+)
+
+// jivaController is the REST client of the jiva controller and replicas,
+// the requests are made with the context of the caller so that they
+// are cancelled along with the RPC, and the retries are stopped
+type jivaController struct {
+	address    string
+	httpClient *http.Client
+}
+
+// newJivaController returns the REST client for the jiva controller
+// (target) of the given volume
+func newJivaController(instance *jv.JivaVolume) (*jivaController, error) {
+	ctrlIP := instance.Spec.ISCSISpec.TargetIP
+	if len(ctrlIP) == 0 {
+		return nil, fmt.Errorf("Target IP is nil")
+	}
+	return newJivaClient(ctrlIP + ":" + jivaControllerPort), nil
+}
+
+// newJivaClient returns the REST client for the jiva
+// controller or replica listening on the given address
+func newJivaClient(address string) *jivaController {
+	if !strings.HasPrefix(address, "http") {
+		address = "http://" + address
+	}
+	if !strings.HasSuffix(address, "/v1") {
+		address += "/v1"
+	}
+	return &jivaController{
+		address:    address,
+		httpClient: &http.Client{Timeout: jivaRequestTimeout},
+	}
+}
+
+// do sends the request to the given path or url and decodes
+// the JSON response into resp if it is not nil
+func (c *jivaController) do(ctx context.Context, method, path string, req, resp interface{}) error {
+	url := path
+	if !strings.HasPrefix(url, "http") {
+		url = c.address + path
+	}
+
+	var body io.Reader
+	if req != nil {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode >= 300 {
+		content, _ := io.ReadAll(httpResp.Body)
+		return fmt.Errorf("Bad response: %d %s: %s", httpResp.StatusCode, httpResp.Status, content)
+	}
+
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// retry retries the request till it succeeds, the retries
+// are stopped as soon as the context is done
+func (c *jivaController) retry(ctx context.Context, method, path string, req, resp interface{}) error {
+	var err error
+	for retryCount := 0; retryCount < httpReqRetryCount; retryCount++ {
+		if err = c.do(ctx, method, path, req, resp); err == nil {
+			return nil
+		}
+		if retryCount == httpReqRetryCount-1 {
+			break
+		}
+		if err := sleep(ctx, httpReqRetryInterval); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// get retries the GET request on the given path
+func (c *jivaController) get(ctx context.Context, path string, obj interface{}) error {
+	return c.retry(ctx, http.MethodGet, path, nil, obj)
+}
+
+// getVolume fetches the volume info served by the jiva controller
+func (c *jivaController) getVolume(ctx context.Context) (*volume.Volume, error) {
+	vol := volume.Volumes{}
+	if err := c.get(ctx, "/volumes", &vol); err != nil {
+		return nil, fmt.Errorf("Failed to get volume info from jiva controller, err: %v", err)
+	}
+
+	if len(vol.Data) == 0 {
+		return nil, fmt.Errorf("Failed to get volume info, no volume found")
+	}
+	return &vol.Data[0], nil
+}
+
+// doAction posts the input to the given action of the volume,
+// i.e. resize, snapshot etc. The request is retried only if retry
+// is set, since a retry of an action which is not idempotent may
+// apply it again if the earlier request timed out after success
+func (c *jivaController) doAction(ctx context.Context, action string, input, output interface{}, retry bool) error {
+	vol, err := c.getVolume(ctx)
+	if err != nil {
+		return err
+	}
+
+	url, ok := vol.Actions[action]
+	if !ok {
+		return fmt.Errorf("Action {%s} is not supported by jiva controller", action)
+	}
+
+	post := c.do
+	if retry {
+		post = c.retry
+	}
+
+	if err := post(ctx, http.MethodPost, url, input, output); err != nil {
+		return fmt.Errorf("Failed to post %s request to jiva controller, err: %v", action, err)
+	}
+	return nil
+}
+
+// getStats fetches the IO stats of the volume served by the jiva controller,
+// the request is not retried since the stats are collected periodically
+func (c *jivaController) getStats(ctx context.Context) (*volume.Stats, error) {
+	stats := &volume.Stats{}
+	if err := c.do(ctx, http.MethodGet, "/stats", nil, stats); err != nil {
+		return nil, fmt.Errorf("Failed to get volume stats from jiva controller, err: %v", err)
+	}
+	return stats, nil
+}
+
+// volUsage is the block usage of the volume reported by a jiva replica,
+// the used blocks of a rebuilding replica grow as the data is synced
+type volUsage struct {
+	UsedLogicalBlocks json.Number `json:"usedlogicalblocks"`
+}
+
+// getVolUsage fetches the block usage of the volume from the replica
+// listening on the given address, the request is not retried since
+// the usage is collected periodically
+func getVolUsage(ctx context.Context, address string) (*volUsage, error) {
+	usage := &volUsage{}
+	if err := replicaClient(address).do(ctx, http.MethodGet, "/replicas/1/volusage", nil, usage); err != nil {
+		return nil, fmt.Errorf("Failed to get volume usage from replica {%s}, err: %v", address, err)
+	}
+	return usage, nil
+}
+
+// replicaClient returns the REST client for the replica listening on the
+// given address, the address is in the tcp://<ip>:<port> form as reported in
+// the JivaVolume status
+func replicaClient(address string) *jivaController {
+	return newJivaClient(strings.TrimPrefix(address, "tcp://"))
+}
+
+// sleep waits for the given duration, it returns
+// the error of the context if it is done before
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "jiva_csi"
+
+var (
+	// durationBuckets ranges from 10ms to ~20min, since the operations
+	// such as staging a volume may wait for the replicas for minutes
+	durationBuckets = prometheus.ExponentialBuckets(0.01, 2, 18)
+
+	grpcRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "grpc_requests_total",
+			Help:      "Total number of CSI gRPC requests by method and status code.",
+		},
+		[]string{"method", "code"},
+	)
+
+	grpcRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Latency of CSI gRPC requests by method.",
+			Buckets:   durationBuckets,
+		},
+		[]string{"method"},
+	)
+
+	iscsiOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "iscsi_operation_duration_seconds",
+			Help:      "Duration of iSCSI login and logout operations.",
+			Buckets:   durationBuckets,
+		},
+		[]string{"operation"},
+	)
+
+	iscsiOperationFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "iscsi_operation_failures_total",
+			Help:      "Total number of failed iSCSI login and logout operations.",
+		},
+		[]string{"operation"},
+	)
+
+	remountsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "remounts_total",
+			Help:      "Total number of remount attempts of the volumes by result.",
+		},
+		[]string{"result"},
+	)
+
+	volumeOperationsStuck = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "volume_operations_stuck",
+			Help:      "Number of volume operations in progress for longer than the stuck operation timeout.",
+		},
+	)
+
+	volumeReadyWaitDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "volume_ready_wait_duration_seconds",
+			Help:      "Time spent waiting for the volumes to be ready while staging.",
+			Buckets:   durationBuckets,
+		},
+		[]string{"result"},
+	)
+)
+
+const (
+	iscsiLogin  = "login"
+	iscsiLogout = "logout"
+
+	resultSuccess = "success"
+	resultFailure = "failure"
+)
+
+func init() {
+	// metrics are served by the manager of the kubernetes
+	// client at the address given by metricsBindAddress
+	metrics.Registry.MustRegister(
+		grpcRequestsTotal,
+		grpcRequestDuration,
+		iscsiOperationDuration,
+		iscsiOperationFailures,
+		remountsTotal,
+		volumeOperationsStuck,
+		volumeReadyWaitDuration,
+	)
+}
+
+// getResult returns the result label of the given error
+func getResult(err error) string {
+	if err != nil {
+		return resultFailure
+	}
+	return resultSuccess
+}
+
+// observeISCSIOperation records the duration of the iSCSI operation
+// started at the given time, and the failure if any
+func observeISCSIOperation(operation string, start time.Time, err error) {
+	iscsiOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		iscsiOperationFailures.WithLabelValues(operation).Inc()
+	}
+}
+
+// metricsGRPC records the count and latency of the grpc requests
+func metricsGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	grpcRequestDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+	grpcRequestsTotal.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	return resp, err
+}
+
+// chainUnaryInterceptors returns the interceptor which calls the given
+// interceptors in order, the last one calls the grpc handler
+func chainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
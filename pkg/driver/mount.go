@@ -17,10 +17,13 @@ limitations under the License.
 package driver
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
 	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
 	"github.com/openebs/jiva-csi/pkg/request"
 	"github.com/openebs/jiva-csi/pkg/utils"
@@ -39,6 +42,10 @@ const (
 	// MonitorMountRetryTimeout indicates the time gap between two consecutive
 	//monitoring attempts
 	MonitorMountRetryTimeout = 5
+
+	// remountTimeout is the timeout of the API calls made
+	// while remounting a volume in the background
+	remountTimeout = 30 * time.Second
 )
 
 type Optfunc func(*NodeMounter)
@@ -48,6 +55,12 @@ type NodeMounter struct {
 	mount.SafeFormatAndMount
 	client *client.Client
 	nodeID string
+	// locks serializes the remount of the volumes
+	// with the RPCs in progress on them
+	locks *request.VolumeLocks
+	// monitored is the time in unix nanoseconds at which
+	// MonitorMounts has started the last monitoring attempt
+	monitored int64
 }
 
 func newNodeMounter() *NodeMounter {
@@ -69,6 +82,12 @@ func withNodeID(nodeID string) Optfunc {
 	}
 }
 
+func withLocks(locks *request.VolumeLocks) Optfunc {
+	return func(n *NodeMounter) {
+		n.locks = locks
+	}
+}
+
 func newNodeMounterWithOpts(opts ...Optfunc) *NodeMounter {
 	nm := newNodeMounter()
 	for _, o := range opts {
@@ -77,19 +96,21 @@ func newNodeMounterWithOpts(opts ...Optfunc) *NodeMounter {
 	return nm
 }
 
+// lastMonitored returns the time at which MonitorMounts
+// has started the last monitoring attempt
+func (m *NodeMounter) lastMonitored() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&m.monitored))
+}
+
 // GetDeviceName get the device name from the mount path
 func (m *NodeMounter) GetDeviceName(mountPath string) (string, int, error) {
 	return mount.GetDeviceNameFromMount(m, mountPath)
 }
 
-func doesVolumeExist(volID string, cli *client.Client) (*jv.JivaVolume, error) {
+func doesVolumeExist(ctx context.Context, volID string, cli *client.Client) (*jv.JivaVolume, error) {
 	volID = utils.StripName(volID)
-	if err := cli.Set(); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
-	}
-
-	instance, err := cli.GetJivaVolume(volID)
-	if err != nil && errors.IsNotFound(err) {
+	instance, err := cli.GetJivaVolume(ctx, volID)
+	if err != nil && (errors.IsNotFound(err) || status.Code(err) == codes.NotFound) {
 		return nil, status.Error(codes.NotFound, err.Error())
 	} else if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
@@ -97,8 +118,8 @@ func doesVolumeExist(volID string, cli *client.Client) (*jv.JivaVolume, error) {
 	return instance, nil
 }
 
-func isVolumeReady(volID string, cli *client.Client) (bool, error) {
-	instance, err := doesVolumeExist(volID, cli)
+func isVolumeReady(ctx context.Context, volID string, cli *client.Client) (bool, error) {
+	instance, err := doesVolumeExist(ctx, volID, cli)
 	if err != nil {
 		return false, err
 	}
@@ -109,9 +130,10 @@ func isVolumeReady(volID string, cli *client.Client) (bool, error) {
 	return false, nil
 }
 
-func isVolumeReachable(targetPortal string) bool {
+func isVolumeReachable(ctx context.Context, targetPortal string) bool {
 	// Create a connection to test if the iSCSI Portal is reachable,
-	if conn, err := net.Dial("tcp", targetPortal); err == nil {
+	dialer := net.Dialer{}
+	if conn, err := dialer.DialContext(ctx, "tcp", targetPortal); err == nil {
 		conn.Close()
 		logrus.Debugf("Target: {%v} is reachable to create connections", targetPortal)
 		return true
@@ -119,57 +141,73 @@ func isVolumeReachable(targetPortal string) bool {
 	return false
 }
 
-func waitForVolumeToBeReady(volID string, cli *client.Client) (*jv.JivaVolume, error) {
-	var retry int
-	var sleepInterval time.Duration = 0
-	for {
-		time.Sleep(sleepInterval * time.Second)
-		instance, err := doesVolumeExist(volID, cli)
-		if err != nil {
-			return nil, err
-		}
+// getVolumeWaitTimeout returns the max time to wait for a volume to reach
+// the desired state, it is derived from the retry count so that the waits
+// finish before kubelet and the sidecars retry the request
+func getVolumeWaitTimeout() time.Duration {
+	return time.Duration(MaxRetryCount) * 5 * time.Second
+}
 
-		retry++
+// waitForVolumeToBeReady waits till the volume is ready to serve IOs, the
+// wait stops as soon as the volume is updated to be ready or the context
+// is done
+func waitForVolumeToBeReady(ctx context.Context, volID string, cli *client.Client) (*jv.JivaVolume, error) {
+	volID = utils.StripName(volID)
+	ctx, cancel := context.WithTimeout(ctx, getVolumeWaitTimeout())
+	defer cancel()
+
+	start := time.Now()
+	instance, err := cli.WaitForJivaVolume(ctx, volID, func(instance *jv.JivaVolume) (bool, error) {
 		if instance.Status.Phase == jv.JivaVolumePhaseReady && instance.Status.Status == "RW" {
-			return instance, nil
-		} else if retry <= MaxRetryCount {
-			sleepInterval = 5
-			if instance.Status.Status == "RO" {
-				replicaStatus := instance.Status.ReplicaStatuses
-				if len(replicaStatus) != 0 {
-					logrus.Warningf("Volume: {%v} is in RO mode: replica status: {%+v}", volID, replicaStatus)
-					continue
-				}
-				logrus.Warningf("Volume: {%v} is not ready: replicas may not be connected", volID)
-				continue
+			return true, nil
+		}
+
+		if instance.Status.Status == "RO" {
+			replicaStatus := instance.Status.ReplicaStatuses
+			if len(replicaStatus) != 0 {
+				logrus.Warningf("Volume: {%v} is in RO mode: replica status: {%+v}", volID, replicaStatus)
+				return false, nil
 			}
-			logrus.Warningf("Volume: {%v} is not ready: volume status is {%s}", volID, instance.Status.Status)
-			continue
-		} else {
-			break
+			logrus.Warningf("Volume: {%v} is not ready: replicas may not be connected", volID)
+			return false, nil
 		}
+		logrus.Warningf("Volume: {%v} is not ready: volume status is {%s}", volID, instance.Status.Status)
+		return false, nil
+	})
+	volumeReadyWaitDuration.WithLabelValues(getResult(err)).Observe(time.Since(start).Seconds())
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		cli.WarningEventf(instance, client.ReasonVolumeNotReady,
+			"Volume is not ready after %v, phase: %s, status: %s",
+			time.Since(start).Round(time.Second), instance.Status.Phase, instance.Status.Status)
+		return nil, fmt.Errorf("volume: {%v} is not ready, err: {%v}", volID, err)
+	} else if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("Max retry count exceeded, volume: {%v} is not ready", volID)
+	return instance, nil
 }
 
-func waitForVolumeToBeReachable(targetPortal string) error {
+func waitForVolumeToBeReachable(ctx context.Context, targetPortal string) error {
 	var (
 		retries int
 		err     error
 		conn    net.Conn
 	)
 
+	dialer := net.Dialer{}
 	for {
 		// Create a connection to test if the iSCSI Portal is reachable,
-		if conn, err = net.Dial("tcp", targetPortal); err == nil {
+		if conn, err = dialer.DialContext(ctx, "tcp", targetPortal); err == nil {
 			conn.Close()
 			logrus.Debugf("Target: {%v} is reachable to create connections", targetPortal)
 			return nil
 		}
 		// wait until the iSCSI targetPortal is reachable
 		// There is no pointn of triggering iSCSIadm login commands
-		// until the portal is reachable
-		time.Sleep(2 * time.Second)
+		// until the portal is reachable, the wait is stopped
+		// as soon as the request is cancelled
+		if err := sleep(ctx, 2*time.Second); err != nil {
+			return err
+		}
 		retries++
 		if retries >= MaxRetryCount {
 			// Let the caller function decide further if the volume is
@@ -195,85 +233,100 @@ func listContains(
 	return nil, false
 }
 
-// MonitorMounts makes sure that all the volumes present in the inmemory list
-// with the driver are mounted with the original mount options
+// MonitorMounts makes sure that all the volumes attached to this node
+// are mounted with the original mount options
 // This function runs a never ending loop therefore should be run as a goroutine
 // Mounted list is fetched from the OS and the state of all the volumes is
 // reverified after every 5 seconds. If the mountpoint is not present in the
-// list or if it has been remounted with a different mount option by the OS, the
-// volume is added to the ReqMountList which is removed as soon as the remount
-// operation on the volume is complete
+// list or if it has been remounted with a different mount option by the OS,
+// the volume is locked for the Remount operation which is unlocked as soon as
+// the remount operation on the volume is complete. Volumes locked by the
+// RPCs in progress are skipped and retried in the next monitoring attempt
 // For each remount operation a new goroutine is created, so that if multiple
 // volumes have lost their original state they can all be remounted in parallel
 func (n *NodeMounter) MonitorMounts() {
 	logrus.Infof("Starting MonitorMounts goroutine")
-	var (
-		err        error
-		csivolList *jv.JivaVolumeList
-		mountList  []mount.MountPoint
-	)
+	atomic.StoreInt64(&n.monitored, time.Now().UnixNano())
 	ticker := time.NewTicker(MonitorMountRetryTimeout * time.Second)
 	for {
 		select {
 		case <-ticker.C:
-			request.TransitionVolListLock.Lock()
-			if mountList, err = n.List(); err != nil {
-				request.TransitionVolListLock.Unlock()
-				logrus.Debugf("MonitorMounts: failed to get list of mount paths, err: {%v}", err)
-				break
-			}
+			atomic.StoreInt64(&n.monitored, time.Now().UnixNano())
+			n.monitorMounts()
+		}
+	}
+}
 
-			// reset the client to avoid caching issue
-			err = n.client.Set()
-			if err != nil {
-				request.TransitionVolListLock.Unlock()
-				logrus.Warningf("MonitorMounts: failed to set client, err: {%v}", err)
-				break
-			}
+// monitorMounts starts the remount of the volumes which have lost their
+// original state, no lock is held while listing the mounts and volumes
+func (n *NodeMounter) monitorMounts() {
+	mountList, err := n.List()
+	if err != nil {
+		logrus.Debugf("MonitorMounts: failed to get list of mount paths, err: {%v}", err)
+		return
+	}
 
-			if csivolList, err = n.client.ListJivaVolumeWithOpts(map[string]string{
-				"nodeID": n.nodeID,
-			}); err != nil {
-				request.TransitionVolListLock.Unlock()
-				logrus.Debugf("MonitorMounts: failed to get list of jiva volumes attached to this node, err: {%v}", err)
-				break
-			}
-			for _, vol := range csivolList.Items {
-				// ignore remount, since volume must be initializing
-				if vol.Spec.MountInfo.StagingPath == "" ||
-					vol.Spec.MountInfo.TargetPath == "" {
-					continue
-				}
-				// Search the volume in the list of mounted volumes at the node
-				// retrieved above
-				stagingMountPoint, stagingPathExists := listContains(
-					vol.Spec.MountInfo.StagingPath, mountList,
-				)
-
-				_, targetPathExists := listContains(
-					vol.Spec.MountInfo.TargetPath, mountList,
-				)
-
-				// If the volume is present in the list verify its state
-				// If stagingPath is in rw then TargetPath will also be in rw
-				// mode
-				if stagingPathExists && targetPathExists && verifyMountOpts(stagingMountPoint.Opts, "rw") {
-					// Continue with remaining volumes since this volume looks
-					// to be in good shape
-					continue
-				}
-
-				if _, ok := request.TransitionVolList[vol.Name]; !ok {
-					request.TransitionVolList[vol.Name] = "Remount"
-					csivol := vol
-					go n.remount(csivol, stagingPathExists, targetPathExists)
-				}
-			}
-			request.TransitionVolListLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), MonitorMountRetryTimeout*time.Second)
+	csivolList, err := n.client.ListJivaVolumeWithOpts(ctx, map[string]string{
+		"nodeID": n.nodeID,
+	})
+	cancel()
+	if err != nil {
+		logrus.Debugf("MonitorMounts: failed to get list of jiva volumes attached to this node, err: {%v}", err)
+		return
+	}
+
+	for _, vol := range csivolList.Items {
+		if _, _, remount := needsRemount(&vol, mountList); !remount {
+			continue
+		}
+
+		unlock, err := n.locks.TryLock(vol.Name, "Remount", lockOwnerMonitorMounts)
+		if err != nil {
+			logrus.Debugf("MonitorMounts: skipping remount of volume: {%s}, err: {%v}", vol.Name, err)
+			continue
 		}
+		go n.remount(vol.Name, unlock)
 	}
 }
 
+// needsRemount returns true if the volume is not mounted at its staging
+// and target paths or if the staging path is not mounted in rw mode
+func needsRemount(vol *jv.JivaVolume, mountList []mount.MountPoint) (stagingPathExists, targetPathExists, remount bool) {
+	// ignore remount, since volume must be initializing
+	if vol.Spec.MountInfo.StagingPath == "" ||
+		vol.Spec.MountInfo.TargetPath == "" {
+		return
+	}
+
+	// raw block volumes are not mounted at staging path
+	// and there is no filesystem which can go to ro state
+	if vol.Spec.MountInfo.FSType == blockFsType {
+		return
+	}
+
+	// read only volumes are not expected to be mounted as rw
+	if vol.Annotations[jivavolume.ReadOnlyKey] == "true" {
+		return
+	}
+	// Search the volume in the list of mounted volumes at the node
+	stagingMountPoint, stagingPathExists := listContains(
+		vol.Spec.MountInfo.StagingPath, mountList,
+	)
+
+	_, targetPathExists = listContains(
+		vol.Spec.MountInfo.TargetPath, mountList,
+	)
+
+	// If the volume is present in the list verify its state
+	// If stagingPath is in rw then TargetPath will also be in rw
+	// mode
+	if stagingPathExists && targetPathExists && verifyMountOpts(stagingMountPoint.Opts, "rw") {
+		return stagingPathExists, targetPathExists, false
+	}
+	return stagingPathExists, targetPathExists, true
+}
+
 func verifyMountOpts(opts []string, desiredOpt string) bool {
 	for _, opt := range opts {
 		if opt == desiredOpt {
@@ -283,27 +336,55 @@ func verifyMountOpts(opts []string, desiredOpt string) bool {
 	return false
 }
 
-func (n *NodeMounter) remount(vol jv.JivaVolume, stagingPathExists, targetPathExists bool) {
-	defer func() {
-		request.TransitionVolListLock.Lock()
-		delete(request.TransitionVolList, vol.Name)
-		request.TransitionVolListLock.Unlock()
-	}()
+// remount remounts the volume locked by MonitorMounts, the volume and
+// its mounts are verified again since they might have been changed by
+// the RPCs completed before the volume was locked
+func (n *NodeMounter) remount(volumeID string, unlock func()) {
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), remountTimeout)
+	defer cancel()
+
+	vol, err := n.client.GetJivaVolume(ctx, volumeID)
+	if err != nil {
+		logrus.Debugf("Remount: failed to get volume: {%s}, err: {%v}", volumeID, err)
+		return
+	}
+	if vol.Labels["nodeID"] != n.nodeID {
+		logrus.Debugf("Remount: volume: {%s} is no longer attached to this node", volumeID)
+		return
+	}
+
+	mountList, err := n.List()
+	if err != nil {
+		logrus.Debugf("Remount: failed to get list of mount paths, err: {%v}", err)
+		return
+	}
+	stagingPathExists, targetPathExists, remount := needsRemount(vol, mountList)
+	if !remount {
+		return
+	}
 
 	logrus.Infof("Remount operation for volume: {%s} started", vol.Name)
-	if err := n.remountVolume(
-		stagingPathExists, targetPathExists,
-		&vol,
-	); err != nil {
+	err = n.remountVolume(
+		ctx, stagingPathExists, targetPathExists,
+		vol,
+	)
+	remountsTotal.WithLabelValues(getResult(err)).Inc()
+	if err != nil {
 		logrus.Errorf(
 			"Remount: mount failed for volume: {%s}, err: {%v}",
 			vol.Name, err,
 		)
+		n.client.WarningEventf(vol, client.ReasonRemountFailed,
+			"Failed to remount volume at %s: %v", vol.Spec.MountInfo.StagingPath, err)
 	} else {
 		logrus.Infof(
 			"Remount: mount successful for volume: {%s}",
 			vol.Name,
 		)
+		n.client.NormalEventf(vol, client.ReasonRemounted,
+			"Remounted volume at %s in rw mode", vol.Spec.MountInfo.StagingPath)
 	}
 }
 
@@ -311,15 +392,16 @@ func (n *NodeMounter) remount(vol jv.JivaVolume, stagingPathExists, targetPathEx
 // state and then tries to mount again. If it is not mounted the volume, first
 // the disk will be attached via iSCSI login and then it will be mounted
 func (n *NodeMounter) remountVolume(
+	ctx context.Context,
 	stagingPathExists bool, targetPathExists bool,
 	vol *jv.JivaVolume,
 ) (err error) {
 	options := []string{"rw"}
 
-	if ready, err := isVolumeReady(vol.Name, n.client); err != nil || !ready {
+	if ready, err := isVolumeReady(ctx, vol.Name, n.client); err != nil || !ready {
 		return fmt.Errorf("Volume is not ready")
 	}
-	if reachable := isVolumeReachable(fmt.Sprintf("%v:%v", vol.Spec.ISCSISpec.TargetIP,
+	if reachable := isVolumeReachable(ctx, fmt.Sprintf("%v:%v", vol.Spec.ISCSISpec.TargetIP,
 		vol.Spec.ISCSISpec.TargetPort)); !reachable {
 		return fmt.Errorf("Volume is not reachable")
 	}
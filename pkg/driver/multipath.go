@@ -0,0 +1,176 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	defaultISCSIPort = "3260"
+
+	// iscsiadm exits with this status if there are no sessions
+	iscsiNoObjectsFound = 21
+
+	// multipathUUIDPrefix is the prefix of the uuid
+	// of the device mapper devices created by multipath
+	multipathUUIDPrefix = "mpath-"
+)
+
+// pathStatus is the health of the iSCSI paths of a volume
+type pathStatus struct {
+	// portals which have a logged in session
+	healthy []string
+	// portals which don't have any session
+	failed []string
+}
+
+func (p pathStatus) String() string {
+	return fmt.Sprintf("healthy: %v, failed: %v", p.healthy, p.failed)
+}
+
+// degraded checks if any of the paths of the volume has failed
+func (p pathStatus) degraded() bool {
+	return len(p.failed) != 0
+}
+
+// getTargetPortals returns the portals of the jiva target of the given
+// volume. The portal of the target service is always used, additional
+// portals of the same target can be given with the target portals
+// annotation, the volume is attached via dm-multipath in that case.
+// jiva-operator exposes the target only via its service, so the
+// annotation is never set by the operator and must be set manually
+func getTargetPortals(instance *jv.JivaVolume) []string {
+	portals := []string{fmt.Sprintf("%v:%v", instance.Spec.ISCSISpec.TargetIP, instance.Spec.ISCSISpec.TargetPort)}
+	seen := map[string]bool{portals[0]: true}
+
+	for _, p := range strings.Split(instance.Annotations[jivavolume.TargetPortalsKey], ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(p); err != nil {
+			p = net.JoinHostPort(p, defaultISCSIPort)
+		}
+		if !seen[p] {
+			seen[p] = true
+			portals = append(portals, p)
+		}
+	}
+	return portals
+}
+
+// getPathStatus returns the health of the iSCSI paths of the given target
+// from the sessions which are logged in on the node
+func getPathStatus(ctx context.Context, exec utilexec.Interface, iqn string, portals []string) (pathStatus, error) {
+	out, err := exec.CommandContext(ctx, "iscsiadm", "-m", "session").CombinedOutput()
+	if err != nil {
+		exitErr, ok := err.(utilexec.ExitError)
+		if !ok || exitErr.ExitStatus() != iscsiNoObjectsFound {
+			return pathStatus{}, fmt.Errorf("failed to list iscsi sessions, err: {%v}, output: {%s}", err, string(out))
+		}
+		out = nil
+	}
+
+	// session is of the format:
+	// tcp: [1] 10.0.0.1:3260,1 iqn.2016-09.com.openebs.jiva:pvc-1 (non-flash)
+	sessions := map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[3] != iqn {
+			continue
+		}
+		sessions[strings.Split(fields[2], ",")[0]] = true
+	}
+
+	status := pathStatus{}
+	for _, p := range portals {
+		if sessions[p] {
+			status.healthy = append(status.healthy, p)
+		} else {
+			status.failed = append(status.failed, p)
+		}
+	}
+	return status, nil
+}
+
+// getMultipathMap returns the name of the dm-multipath map which holds
+// the iSCSI devices of the given target, it is empty if the devices
+// are not held by a multipath map
+func getMultipathMap(iqn string, portals []string) (string, error) {
+	for _, p := range portals {
+		path := fmt.Sprintf("/dev/disk/by-path/ip-%s-iscsi-%s-lun-%d", p, iqn, defaultISCSILUN)
+		dev, err := filepath.EvalSymlinks(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		holders, err := filepath.Glob(filepath.Join("/sys/block", filepath.Base(dev), "holders", "dm-*"))
+		if err != nil {
+			return "", err
+		}
+
+		for _, holder := range holders {
+			uuid, err := os.ReadFile(filepath.Join(holder, "dm", "uuid"))
+			if err != nil || !strings.HasPrefix(string(uuid), multipathUUIDPrefix) {
+				continue
+			}
+
+			name, err := os.ReadFile(filepath.Join(holder, "dm", "name"))
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimSpace(string(name)), nil
+		}
+	}
+	return "", nil
+}
+
+// flushMultipathMap flushes the dm-multipath map of the given target before
+// logging out of its sessions, otherwise the map is left behind with failed
+// paths and queues the I/O if queue_if_no_path is set
+func flushMultipathMap(ctx context.Context, exec utilexec.Interface, iqn string, portals []string) error {
+	name, err := getMultipathMap(iqn, portals)
+	if err != nil {
+		return fmt.Errorf("failed to find multipath map of target: {%s}, err: {%v}", iqn, err)
+	}
+
+	if name == "" {
+		return nil
+	}
+
+	out, err := exec.CommandContext(ctx, "multipath", "-f", name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to flush multipath map: {%s} of target: {%s}, err: {%v}, output: {%s}",
+			name, iqn, err, string(out))
+	}
+
+	logrus.Infof("NodeUnstageVolume: flushed multipath map: {%s} of target: {%s}", name, iqn)
+	return nil
+}
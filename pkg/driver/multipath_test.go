@@ -0,0 +1,52 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = DescribeTable("getTargetPortals",
+	func(annotation string, portals []string) {
+		instance := &jv.JivaVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					jivavolume.TargetPortalsKey: annotation,
+				},
+			},
+			Spec: jv.JivaVolumeSpec{
+				ISCSISpec: jv.ISCSISpec{
+					TargetIP:   "10.0.0.1",
+					TargetPort: 3260,
+				},
+			},
+		}
+		Expect(getTargetPortals(instance)).Should(Equal(portals))
+	},
+	Entry("service portal only", "", []string{"10.0.0.1:3260"}),
+	Entry("additional portals", "10.0.0.2:3260,10.0.0.3:3261",
+		[]string{"10.0.0.1:3260", "10.0.0.2:3260", "10.0.0.3:3261"}),
+	Entry("default port is added", "10.0.0.2", []string{"10.0.0.1:3260", "10.0.0.2:3260"}),
+	Entry("spaces and empty portals are skipped", " 10.0.0.2:3260 , ,",
+		[]string{"10.0.0.1:3260", "10.0.0.2:3260"}),
+	Entry("duplicate portals are skipped", "10.0.0.1,10.0.0.2:3260,10.0.0.2",
+		[]string{"10.0.0.1:3260", "10.0.0.2:3260"}),
+)
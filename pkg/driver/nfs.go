@@ -0,0 +1,273 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/openebs/jiva-csi/pkg/utils"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/cloud-provider/volume/helpers"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// nfsGatewayParam is the storage class parameter which enables
+	// the NFS gateway for the volume to support multi node access
+	nfsGatewayParam = "nfsGateway"
+	// nfsServerImageParam is the storage class parameter which used to
+	// set the image of NFS server, it is rejected since the NFS server
+	// runs privileged so the image is set only by the controller plugin
+	nfsServerImageParam = "nfsServerImage"
+
+	// nfsGatewayPollInterval is the interval at which the NFS
+	// server is verified to be available while creating the volume
+	nfsGatewayPollInterval = 2 * time.Second
+
+	// nfsServerKey and nfsExportKey are the volume context keys
+	// which are used by node plugin to mount the NFS export
+	nfsServerKey = "nfsServer"
+	nfsExportKey = "nfsExport"
+
+	nfsFsType = "nfs"
+)
+
+// isNFSGatewayEnabled checks if the volume is
+// requested to be served via NFS gateway
+func isNFSGatewayEnabled(params map[string]string) bool {
+	enabled := strings.ToLower(params[nfsGatewayParam])
+	return enabled == "true"
+}
+
+// isMultiNodeAccessMode checks if the given access
+// mode allows the volume to be used on multiple nodes
+func isMultiNodeAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return true
+	}
+	return false
+}
+
+// getNFSBackendName returns the name of the jiva volume which backs the NFS
+// gateway of the given volume. The name must not contain the volume ID, since
+// the mounts of a volume on a node are searched by the volume ID
+func getNFSBackendName(volumeID string) string {
+	return utils.StripName("nfs-" + strings.TrimPrefix(volumeID, "pvc-"))
+}
+
+// getJivaVolume returns the JivaVolume of the given volume, a volume
+// served via NFS gateway has no JivaVolume of its own so its backend
+// jiva volume is returned
+func (cs *controller) getJivaVolume(ctx context.Context, volumeID string) (*jv.JivaVolume, error) {
+	instance, err := cs.client.GetJivaVolume(ctx, volumeID)
+	if status.Code(err) != codes.NotFound {
+		return instance, err
+	}
+
+	backend, berr := cs.client.GetJivaVolume(ctx, getNFSBackendName(volumeID))
+	if status.Code(berr) == codes.NotFound ||
+		(berr == nil && backend.Annotations[jivavolume.NFSGatewayVolumeKey] != volumeID) {
+		return nil, err
+	}
+	return backend, berr
+}
+
+// isNFSBackend checks if the given jiva volume
+// is the backend of the NFS gateway of a volume
+func isNFSBackend(instance *jv.JivaVolume) bool {
+	return instance.Annotations[jivavolume.NFSGatewayVolumeKey] != ""
+}
+
+// createNFSGateway creates the backend jiva volume and the NFS server
+// which exports it, it returns the context used by node plugin to
+// mount the NFS export
+func (cs *controller) createNFSGateway(ctx context.Context, req *csi.CreateVolumeRequest) (map[string]string, error) {
+	volumeID := utils.StripName(req.GetName())
+	backend := getNFSBackendName(volumeID)
+
+	backendReq := proto.Clone(req).(*csi.CreateVolumeRequest)
+	backendReq.Name = backend
+	if err := cs.client.CreateJivaVolume(ctx, backendReq); err != nil {
+		return nil, err
+	}
+
+	if err := cs.setNFSGatewayVolume(ctx, backend, volumeID); err != nil {
+		return nil, err
+	}
+
+	sizeBytes := req.GetCapacityRange().GetRequiredBytes()
+	if sizeBytes == 0 {
+		sizeBytes = 5 * helpers.GiB
+	}
+
+	fsType := defaultFsType
+	for _, volCap := range req.GetVolumeCapabilities() {
+		if t := volCap.GetMount().GetFsType(); t != "" {
+			fsType = t
+		}
+	}
+
+	gw := &client.NFSGateway{
+		VolumeID:   volumeID,
+		Backend:    backend,
+		Namespace:  client.GetNamespace(req.GetParameters()),
+		Capacity:   *resource.NewQuantity(sizeBytes, resource.BinarySI),
+		DriverName: cs.driver.config.DriverName,
+		FSType:     fsType,
+		Image:      cs.driver.config.NFSServerImage,
+	}
+	serverIP, err := cs.client.CreateNFSGateway(ctx, gw)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateVolume: failed to create NFS gateway of volume: {%s}, err: {%v}", volumeID, err)
+	}
+
+	if err := cs.waitForNFSGateway(ctx, gw); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("CreateVolume: volume: {%s} is served by NFS server: {%s} backed by jiva volume: {%s}",
+		volumeID, serverIP, backend)
+	return map[string]string{
+		nfsServerKey: serverIP,
+		nfsExportKey: "/",
+	}, nil
+}
+
+// setNFSGatewayVolume records the ID of the volume served by the NFS
+// gateway on its backend jiva volume, so that the backend is listed as
+// the volume by ListVolumes
+func (cs *controller) setNFSGatewayVolume(ctx context.Context, backend, volumeID string) error {
+	instance, err := cs.client.GetJivaVolume(ctx, backend)
+	if err != nil {
+		return err
+	}
+
+	if instance.Annotations[jivavolume.NFSGatewayVolumeKey] == volumeID {
+		return nil
+	}
+
+	if err := cs.client.PatchAnnotations(ctx, instance, map[string]*string{
+		jivavolume.NFSGatewayVolumeKey: pointer.StringPtr(volumeID),
+	}); err != nil {
+		return status.Errorf(codes.Internal,
+			"CreateVolume: failed to set volume: {%s} on NFS gateway backend: {%s}, err: {%v}", volumeID, backend, err)
+	}
+	return nil
+}
+
+// waitForNFSGateway waits till the NFS server of the given gateway is
+// available, so that the volume is not published before it can be mounted.
+// Aborted is returned on timeout so that the request is retried by the
+// provisioner, the resources of the gateway are not created again
+func (cs *controller) waitForNFSGateway(ctx context.Context, gw *client.NFSGateway) error {
+	ctx, cancel := context.WithTimeout(ctx, getVolumeWaitTimeout())
+	defer cancel()
+
+	for {
+		available, err := cs.client.IsNFSGatewayAvailable(ctx, gw)
+		if err == nil && available {
+			return nil
+		}
+		if err != nil {
+			logrus.Warningf("CreateVolume: failed to get NFS server of volume: {%s}, err: {%v}", gw.VolumeID, err)
+		}
+
+		if err := sleep(ctx, nfsGatewayPollInterval); err != nil {
+			return status.Errorf(codes.Aborted,
+				"CreateVolume: waiting for NFS server of volume: {%s} to be available", gw.VolumeID)
+		}
+	}
+}
+
+// deleteNFSGateway deletes the NFS gateway and the backend jiva volume
+// of the given volume, it is a no-op if the volume is not served via
+// NFS gateway
+func (cs *controller) deleteNFSGateway(ctx context.Context, volumeID string) error {
+	pending, err := cs.client.DeleteNFSGateway(ctx, volumeID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "DeleteVolume: failed to delete NFS gateway of volume: {%s}, err: {%v}", volumeID, err)
+	}
+
+	// backend jiva volume must not be deleted until it is
+	// detached from the node on which NFS server was running
+	if pending {
+		return status.Errorf(codes.Aborted, "DeleteVolume: waiting for NFS gateway of volume: {%s} to be removed", volumeID)
+	}
+
+	if err := cs.client.DeleteJivaVolume(ctx, getNFSBackendName(volumeID)); err != nil {
+		return status.Errorf(codes.Internal, "DeleteVolume: failed to delete backend of volume: {%s}, err: {%v}", volumeID, err)
+	}
+	return nil
+}
+
+// isNFSVolume checks if the volume is served via NFS gateway
+// from the volume context
+func isNFSVolume(volumeContext map[string]string) bool {
+	return volumeContext[nfsServerKey] != ""
+}
+
+// nodePublishVolumeForNFS mounts the NFS export of the
+// volume at the target path
+func (ns *node) nodePublishVolumeForNFS(req *csi.NodePublishVolumeRequest, mountOptions []string) error {
+	target := req.GetTargetPath()
+	volumeContext := req.GetVolumeContext()
+	source := fmt.Sprintf("%s:%s", volumeContext[nfsServerKey], volumeContext[nfsExportKey])
+
+	// bind is not required for NFS mounts
+	var options []string
+	for _, o := range mountOptions {
+		if o != "bind" {
+			options = append(options, o)
+		}
+	}
+	options = append(options, req.GetVolumeCapability().GetMount().GetMountFlags()...)
+
+	notMnt, err := ns.mounter.IsLikelyNotMountPoint(target)
+	if err != nil && !os.IsNotExist(err) {
+		return status.Errorf(codes.Internal, "Could not check if {%q} is mounted, err: %v", target, err)
+	}
+
+	if err == nil && !notMnt {
+		logrus.Infof("NodePublishVolume: volume: {%s} is already mounted at {%s}", req.GetVolumeId(), target)
+		return nil
+	}
+
+	if err := os.MkdirAll(target, 0750); err != nil {
+		return status.Errorf(codes.Internal, "Could not create dir {%q}, err: %v", target, err)
+	}
+
+	logrus.Infof("NodePublishVolume: start mounting: NFS export: {%s} at target: {%s} with options: {%s}", source, target, options)
+	if err := ns.mounter.Mount(source, target, nfsFsType, options); err != nil {
+		return status.Errorf(codes.Internal, "Could not mount %q at %q: %v", source, target, err)
+	}
+	return nil
+}
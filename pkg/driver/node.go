@@ -19,12 +19,14 @@ package driver
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/csi-lib-iscsi/iscsi"
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
 	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
-	"github.com/openebs/jiva-csi/pkg/request"
 	"github.com/openebs/jiva-csi/pkg/utils"
 	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
 	"github.com/sirupsen/logrus"
@@ -32,6 +34,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/utils/pointer"
 )
 
 const (
@@ -46,8 +49,16 @@ const (
 
 	defaultFsType = FSTypeExt4
 
+	// blockFsType is set as the fsType in the mount info of
+	// JivaVolume if the volume is consumed as a raw block device
+	blockFsType = "block"
+
 	defaultISCSILUN       = int32(0)
 	defaultISCSIInterface = "default"
+
+	// abortStagingTimeout is the timeout to detach the
+	// volume attached by a cancelled NodeStageVolume
+	abortStagingTimeout = 30 * time.Second
 )
 
 var (
@@ -64,6 +75,7 @@ var (
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
 		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 	}
 )
 
@@ -71,11 +83,17 @@ type nodeStageRequest struct {
 	stagingPath string
 	fsType      string
 	volumeID    string
+	isBlock     bool
+	readOnly    bool
+	encrypted   bool
+	passphrase  string
+	iscsi       iscsiParams
 }
 
 // node is the server implementation
 // for CSI NodeServer
 type node struct {
+	csi.UnimplementedNodeServer
 	client  *client.Client
 	driver  *CSIDriver
 	mounter *NodeMounter
@@ -91,26 +109,53 @@ func NewNode(d *CSIDriver, cli *client.Client) *node {
 	}
 }
 
-func (ns *node) attachDisk(instance *jv.JivaVolume) (string, error) {
+func (ns *node) attachDisk(ctx context.Context, instance *jv.JivaVolume, params iscsiParams) (devicePath string, err error) {
+	defer func(start time.Time) {
+		observeISCSIOperation(iscsiLogin, start, err)
+	}(time.Now())
+
 	connector := iscsi.Connector{
 		VolumeName:    instance.Name,
 		TargetIqn:     instance.Spec.ISCSISpec.Iqn,
 		Lun:           defaultISCSILUN,
-		Interface:     defaultISCSIInterface,
-		TargetPortals: []string{fmt.Sprintf("%v:%v", instance.Spec.ISCSISpec.TargetIP, instance.Spec.ISCSISpec.TargetPort)},
+		Interface:     params.iface,
+		TargetPortals: getTargetPortals(instance),
 		DoDiscovery:   true,
 	}
+	connector.Multipath = len(connector.TargetPortals) > 1
+
+	// settings of the iface are saved before login, since iscsi lib
+	// deletes the iface if it fails to login on all the portals
+	iface, err := getISCSIIface(ctx, ns.mounter.Exec, params.iface)
+	if err != nil {
+		return "", err
+	}
+
+	if err = configureISCSINode(ctx, ns.mounter.Exec, &connector, params); err != nil {
+		return "", err
+	}
+
+	// login is not cancelled once started since iscsi lib
+	// doesn't take a context, it is bounded by its own retries
+	if err = ctx.Err(); err != nil {
+		return "", err
+	}
 
 	logrus.Debugf("NodeStageVolume: attach disk with config: {%+v}", connector)
-	devicePath, err := iscsi.Connect(connector)
+	devicePath, err = iscsi.Connect(connector)
 	if err != nil {
+		// iface is restored even if the request is cancelled
+		if rerr := restoreISCSIIface(context.Background(), ns.mounter.Exec, params.iface, iface); rerr != nil {
+			logrus.Errorf("NodeStageVolume: %v", rerr)
+		}
 		return "", err
 	}
 
 	if devicePath == "" {
-		return "", fmt.Errorf("connect reported success, but no path returned")
+		err = fmt.Errorf("connect reported success, but no path returned")
+		return "", err
 	}
-	return devicePath, err
+	return devicePath, nil
 }
 
 func (ns *node) validateStagingReq(req *csi.NodeStageVolumeRequest) (nodeStageRequest, error) {
@@ -129,14 +174,20 @@ func (ns *node) validateStagingReq(req *csi.NodeStageVolumeRequest) (nodeStageRe
 		return nodeStageRequest{}, status.Error(codes.InvalidArgument, "Volume capability not supported")
 	}
 
-	mount := volCap.GetMount()
-	if mount == nil {
-		return nodeStageRequest{}, status.Error(codes.InvalidArgument, "NodeStageVolume: mount is nil within volume capability")
-	}
+	var fsType string
+	isBlock := volCap.GetBlock() != nil
+	if isBlock {
+		fsType = blockFsType
+	} else {
+		mount := volCap.GetMount()
+		if mount == nil {
+			return nodeStageRequest{}, status.Error(codes.InvalidArgument, "NodeStageVolume: mount is nil within volume capability")
+		}
 
-	fsType := mount.GetFsType()
-	if len(fsType) == 0 {
-		fsType = defaultFsType
+		fsType = mount.GetFsType()
+		if len(fsType) == 0 {
+			fsType = defaultFsType
+		}
 	}
 
 	stagingPath := req.GetStagingTargetPath()
@@ -144,10 +195,31 @@ func (ns *node) validateStagingReq(req *csi.NodeStageVolumeRequest) (nodeStageRe
 		return nodeStageRequest{}, status.Error(codes.InvalidArgument, "staging path is empty")
 	}
 
+	if err := validateCHAPSecrets(req.GetSecrets()); err != nil {
+		return nodeStageRequest{}, status.Errorf(codes.InvalidArgument, "NodeStageVolume: %v", err)
+	}
+
+	iscsiParams, err := getISCSIParams(ns.driver.config.ISCSI, req.GetVolumeContext())
+	if err != nil {
+		return nodeStageRequest{}, status.Errorf(codes.InvalidArgument, "NodeStageVolume: %v", err)
+	}
+
+	encrypted := isEncryptionEnabled(req.GetVolumeContext())
+	passphrase := req.GetSecrets()[encryptionPassphraseKey]
+	if encrypted && passphrase == "" {
+		return nodeStageRequest{}, status.Errorf(codes.InvalidArgument,
+			"NodeStageVolume: secret {%s} is required for encrypted volume", encryptionPassphraseKey)
+	}
+
 	return nodeStageRequest{
 		volumeID:    volID,
 		fsType:      fsType,
 		stagingPath: stagingPath,
+		isBlock:     isBlock,
+		readOnly:    isReadOnlyAccessMode(volCap),
+		encrypted:   encrypted,
+		passphrase:  passphrase,
+		iscsi:       iscsiParams,
 	}, nil
 }
 
@@ -158,23 +230,39 @@ func (ns *node) validateStagingReq(req *csi.NodeStageVolumeRequest) (nodeStageRe
 func (ns *node) NodeStageVolume(
 	ctx context.Context,
 	req *csi.NodeStageVolumeRequest,
-) (*csi.NodeStageVolumeResponse, error) {
+) (_ *csi.NodeStageVolumeResponse, err error) {
 
 	reqParam, err := ns.validateStagingReq(req)
 	if err != nil {
 		return nil, err
 	}
 
+	// NFS export is mounted directly at the target path
+	// in NodePublishVolume
+	if isNFSVolume(req.GetVolumeContext()) {
+		logrus.Infof("NodeStageVolume: volume: {%q} is served via NFS gateway, skip staging", reqParam.volumeID)
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
 	logrus.Infof("NodeStageVolume: start staging volume: {%q}", reqParam.volumeID)
-	if err := request.AddVolumeToTransitionList(reqParam.volumeID, "NodeStageVolume"); err != nil {
-		return nil, status.Error(codes.Aborted, err.Error())
+	unlock, err := ns.driver.lockVolume(reqParam.volumeID, "NodeStageVolume")
+	if err != nil {
+		return nil, err
 	}
+	defer unlock()
 
-	defer request.RemoveVolumeFromTransitionList(reqParam.volumeID)
+	// the request may be cancelled by the CO while waiting for the
+	// volume or the API server, such failures are reported with the
+	// code of the context instead of the code of the step which failed
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = status.FromContextError(ctx.Err()).Err()
+		}
+	}()
 
 	// Check if volume is ready to serve IOs,
 	// info is fetched from the JivaVolume CR
-	instance, err := waitForVolumeToBeReady(reqParam.volumeID, ns.client)
+	instance, err := waitForVolumeToBeReady(ctx, reqParam.volumeID, ns.client)
 	if err != nil {
 		return nil, status.Error(codes.FailedPrecondition, err.Error())
 	}
@@ -184,57 +272,177 @@ func (ns *node) NodeStageVolume(
 		return nil, err
 	}
 
+	if err := ns.verifyPublishedNode(instance); err != nil {
+		return nil, err
+	}
+
+	if err := ns.checkVolumeLimit(ctx, instance); err != nil {
+		return nil, err
+	}
+
 	// A temporary TCP connection is made to the volume to check if its
 	// reachable
 	if err := waitForVolumeToBeReachable(
-		fmt.Sprintf("%v:%v", instance.Spec.ISCSISpec.TargetIP,
+		ctx, fmt.Sprintf("%v:%v", instance.Spec.ISCSISpec.TargetIP,
 			instance.Spec.ISCSISpec.TargetPort),
 	); err != nil {
 		return nil,
 			status.Error(codes.FailedPrecondition, err.Error())
 	}
 
-	devicePath, err := ns.attachDisk(instance)
+	devicePath, err := ns.attachDisk(ctx, instance, reqParam.iscsi)
 	if err != nil {
 		logrus.Errorf("NodeStageVolume: failed to attachDisk for volume: {%v}, err: {%v}", reqParam.volumeID, err)
+		ns.client.WarningEventf(instance, client.ReasonAttachFailed,
+			"Failed to attach volume on node %s: %v", ns.driver.config.NodeID, err)
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	// volume is detached if the request is cancelled before it is
+	// staged, so that the retried request doesn't find the volume
+	// half staged, the volume lock is released after the cleanup
+	defer func(instance *jv.JivaVolume) {
+		if err != nil && ctx.Err() != nil {
+			ns.abortStaging(instance)
+		}
+	}(instance)
+
+	// volume may be staged on multiple nodes, so the device is set
+	// read only to avoid any writes on it including journal replay
+	if reqParam.readOnly {
+		if err := ns.setDeviceReadOnly(devicePath); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	// filesystem is created on the dm-crypt mapping of the
+	// encrypted volume instead of the iSCSI device
+	if reqParam.encrypted {
+		devicePath, err = ns.openEncryptedDevice(reqParam.volumeID, devicePath, reqParam.passphrase, reqParam.readOnly)
+		if err != nil {
+			logrus.Errorf("NodeStageVolume: failed to open encrypted volume: {%v}, err: {%v}", reqParam.volumeID, err)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
 	// JivaVolume CR may be updated by jiva-operator
-	instance, err = ns.client.GetJivaVolume(reqParam.volumeID)
+	instance, err = ns.client.GetJivaVolume(ctx, reqParam.volumeID)
 	if err != nil {
 		return nil, err
 	}
 
-	instance.Spec.MountInfo.FSType = reqParam.fsType
-	instance.Spec.MountInfo.DevicePath = devicePath
-	instance.Spec.MountInfo.StagingPath = reqParam.stagingPath
-	instance.Labels["nodeID"] = ns.driver.config.NodeID
-	if err := ns.client.UpdateJivaVolume(instance); err != nil {
+	patch, err := ns.getStagePatch(reqParam, devicePath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := ns.client.PatchJivaVolume(ctx, instance, patch); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	// raw block volumes are neither formatted nor mounted at staging
+	// path, the device is bind mounted to the target path in NodePublish
+	if reqParam.isBlock {
+		logrus.Infof("NodeStageVolume: volume: {%v} is attached as block device at {%v}", reqParam.volumeID, devicePath)
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
 	if err := os.MkdirAll(reqParam.stagingPath, 0750); err != nil {
 		logrus.Errorf("Failed to mkdir %s, error: %v", reqParam.stagingPath, err)
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	logrus.Infof("NodeStageVolume: start format and mount operation on volume: {%v}", reqParam.volumeID)
-	if err := ns.formatAndMount(req, instance.Spec.MountInfo.DevicePath); err != nil {
+	if err := ns.formatAndMount(req, devicePath, reqParam.readOnly); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
-func (ns *node) doesVolumeExist(volID string) (*jv.JivaVolume, error) {
-	volID = utils.StripName(volID)
-	if err := ns.client.Set(); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+// getStagePatch returns the patch of the JivaVolume which records the
+// volume staged on this node. Read only volumes may be staged on multiple
+// nodes, so they are recorded in an annotation per node instead of the
+// nodeID label and the mount info
+func (ns *node) getStagePatch(reqParam nodeStageRequest, devicePath string) (client.JivaVolumePatch, error) {
+	nodeID := ns.driver.config.NodeID
+	if !reqParam.readOnly {
+		return client.JivaVolumePatch{
+			Labels: map[string]*string{
+				"nodeID": pointer.StringPtr(nodeID),
+			},
+			MountInfo: &client.MountInfoPatch{
+				FSType:      pointer.StringPtr(reqParam.fsType),
+				DevicePath:  pointer.StringPtr(devicePath),
+				StagingPath: pointer.StringPtr(reqParam.stagingPath),
+			},
+		}, nil
 	}
 
-	instance, err := ns.client.GetJivaVolume(volID)
-	if err != nil && errors.IsNotFound(err) {
+	attachment, err := getReadOnlyAttachmentValue(readOnlyAttachment{
+		NodeID:      nodeID,
+		DevicePath:  devicePath,
+		StagingPath: reqParam.stagingPath,
+		FSType:      reqParam.fsType,
+	})
+	if err != nil {
+		return client.JivaVolumePatch{}, err
+	}
+	return client.JivaVolumePatch{
+		Annotations: map[string]*string{
+			jivavolume.ReadOnlyKey:           pointer.StringPtr("true"),
+			getReadOnlyAttachmentKey(nodeID): pointer.StringPtr(attachment),
+		},
+	}, nil
+}
+
+// abortStaging detaches the volume which was attached by the cancelled
+// NodeStageVolume, the cleanup is not bound to the context of the request
+func (ns *node) abortStaging(instance *jv.JivaVolume) {
+	logrus.Warningf("NodeStageVolume: request for volume: {%s} is cancelled, detaching the volume", instance.Name)
+	ctx, cancel := context.WithTimeout(context.Background(), abortStagingTimeout)
+	defer cancel()
+	if _, err := ns.detachDisk(ctx, instance); err != nil {
+		logrus.Errorf("NodeStageVolume: failed to detach volume: {%s} of cancelled request, err: {%v}", instance.Name, err)
+	}
+}
+
+// checkVolumeLimit verifies that the given volume can be staged without
+// exceeding the max volumes per node, the volumes are counted from the
+// JivaVolumes which are labeled with the ID of this node or have a read
+// only attachment on this node
+func (ns *node) checkVolumeLimit(ctx context.Context, instance *jv.JivaVolume) error {
+	limit := ns.driver.config.MaxVolumesPerNode
+	nodeID := ns.driver.config.NodeID
+	if limit <= 0 || isAttachedToNode(instance, nodeID) {
+		return nil
+	}
+
+	volumes, err := ns.client.ListJivaVolumeWithOpts(ctx, map[string]string{
+		"openebs.io/component": "jiva-volume",
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "NodeStageVolume: failed to list volumes of node: {%s}, err: {%v}", nodeID, err)
+	}
+
+	var count int64
+	for i := range volumes.Items {
+		if isAttachedToNode(&volumes.Items[i], nodeID) {
+			count++
+		}
+	}
+
+	if count >= limit {
+		return status.Errorf(codes.ResourceExhausted,
+			"NodeStageVolume: node: {%s} already has {%d} volumes staged, max volumes per node: {%d}",
+			nodeID, count, limit)
+	}
+	return nil
+}
+
+func (ns *node) doesVolumeExist(ctx context.Context, volID string) (*jv.JivaVolume, error) {
+	volID = utils.StripName(volID)
+	instance, err := ns.client.GetJivaVolume(ctx, volID)
+	if err != nil && (errors.IsNotFound(err) || status.Code(err) == codes.NotFound) {
 		return nil, status.Error(codes.NotFound, err.Error())
 	} else if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
@@ -262,11 +470,11 @@ func (ns *node) NodeUnstageVolume(
 	}
 
 	logrus.Infof("NodeUnstageVolume: start unstaging volume: {%q}", volID)
-	if err := request.AddVolumeToTransitionList(volID, "NodeUnStageVolume"); err != nil {
-		return nil, status.Error(codes.Aborted, err.Error())
+	unlock, err := ns.driver.lockVolume(volID, "NodeUnStageVolume")
+	if err != nil {
+		return nil, err
 	}
-
-	defer request.RemoveVolumeFromTransitionList(volID)
+	defer unlock()
 
 	// Check if target directory is a mount point. GetDeviceNameFromMount
 	// given a mnt point, finds the device from /proc/mounts
@@ -280,9 +488,19 @@ func (ns *node) NodeUnstageVolume(
 	// From the spec: If the volume corresponding to the volume_id
 	// is not staged to the staging_target_path, the Plugin MUST
 	// reply 0 OK.
+	// Raw block volumes are never mounted at the staging path, so
+	// such volumes are detached if they are staged at the target.
 	if refCount == 0 {
-		logrus.Infof("NodeUnstageVolume: %s target not mounted", target)
-		return &csi.NodeUnstageVolumeResponse{}, nil
+		instance, err := doesVolumeExist(ctx, volID, ns.client)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return nil, err
+		}
+
+		if err != nil || !ns.isStagedAsBlock(instance, target) {
+			logrus.Infof("NodeUnstageVolume: %s target not mounted", target)
+			return &csi.NodeUnstageVolumeResponse{}, nil
+		}
+		return ns.detachDisk(ctx, instance)
 	}
 
 	if refCount > 1 {
@@ -295,27 +513,86 @@ func (ns *node) NodeUnstageVolume(
 		return nil, status.Errorf(codes.Internal, "Could not unmount target %q: %v", target, err)
 	}
 
-	instance, err := doesVolumeExist(volID, ns.client)
+	instance, err := doesVolumeExist(ctx, volID, ns.client)
 	if err != nil {
 		return nil, err
 	}
 
+	return ns.detachDisk(ctx, instance)
+}
+
+// isStagedAsBlock checks if the given volume is staged on this
+// node at the given path as a raw block device
+func (ns *node) isStagedAsBlock(instance *jv.JivaVolume, stagingPath string) bool {
+	if attachment, ok := getReadOnlyAttachments(instance)[ns.driver.config.NodeID]; ok {
+		return attachment.FSType == blockFsType && attachment.StagingPath == stagingPath
+	}
+	return instance.Spec.MountInfo.FSType == blockFsType &&
+		instance.Spec.MountInfo.StagingPath == stagingPath
+}
+
+// getUnstagePatch returns the patch of the JivaVolume which removes the
+// volume staged on this node and its staging path. The read only flag of
+// the volume is cleared along with the last read only attachment
+func (ns *node) getUnstagePatch(instance *jv.JivaVolume) (client.JivaVolumePatch, string) {
+	nodeID := ns.driver.config.NodeID
+	attachments := getReadOnlyAttachments(instance)
+	attachment, ok := attachments[nodeID]
+	if !ok {
+		return client.JivaVolumePatch{
+			Labels: map[string]*string{
+				"nodeID": pointer.StringPtr(""),
+			},
+			MountInfo: &client.MountInfoPatch{
+				StagingPath: pointer.StringPtr(""),
+			},
+		}, instance.Spec.MountInfo.StagingPath
+	}
+
+	patch := client.JivaVolumePatch{
+		Annotations: map[string]*string{
+			getReadOnlyAttachmentKey(nodeID): nil,
+		},
+	}
+	if len(attachments) == 1 {
+		patch.Annotations[jivavolume.ReadOnlyKey] = nil
+	}
+	return patch, attachment.StagingPath
+}
+
+// detachDisk logs out from the iSCSI target of the volume and
+// resets the mount info of the volume
+func (ns *node) detachDisk(ctx context.Context, instance *jv.JivaVolume) (*csi.NodeUnstageVolumeResponse, error) {
+	if err := ns.closeEncryptedDevice(instance.Name); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
 	tgtIP := instance.Spec.ISCSISpec.TargetIP
-	logrus.Infof("NodeUnstageVolume: disconnect from iscsi target: {%s}", tgtIP)
-	if err := iscsi.Disconnect(instance.Spec.ISCSISpec.Iqn, []string{fmt.Sprintf("%v:%v",
-		tgtIP, instance.Spec.ISCSISpec.TargetPort)}); err != nil {
+	portals := getTargetPortals(instance)
+	if err := flushMultipathMap(ctx, ns.mounter.Exec, instance.Spec.ISCSISpec.Iqn, portals); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if err := os.RemoveAll(instance.Spec.MountInfo.StagingPath); err != nil {
+	logrus.Infof("NodeUnstageVolume: disconnect from iscsi target: {%s}, portals: {%v}", tgtIP, portals)
+	start := time.Now()
+	err := iscsi.Disconnect(instance.Spec.ISCSISpec.Iqn, portals)
+	observeISCSIOperation(iscsiLogout, start, err)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	_, stagingPath := ns.getUnstagePatch(instance)
+	if err := os.RemoveAll(stagingPath); err != nil {
 		logrus.Errorf("Failed to remove mount path, err: {%v}", err)
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	// Setting to empty
-	instance.Spec.MountInfo.StagingPath = ""
-	instance.Labels["nodeID"] = ""
-	if err := ns.client.UpdateJivaVolume(instance); err != nil {
+	// Setting to empty, the patch is computed again on conflict since
+	// the last reader of a read only volume clears the read only key
+	if err := ns.client.PatchJivaVolumeFunc(ctx, instance, func(obj *jv.JivaVolume) (client.JivaVolumePatch, error) {
+		patch, _ := ns.getUnstagePatch(obj)
+		return patch, nil
+	}); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
@@ -324,7 +601,23 @@ func (ns *node) NodeUnstageVolume(
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
-func (ns *node) formatAndMount(req *csi.NodeStageVolumeRequest, devicePath string) error {
+// isReadOnlyAccessMode checks if the volume is requested to
+// be published as read only on multiple nodes
+func isReadOnlyAccessMode(volCap *csi.VolumeCapability) bool {
+	return volCap.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+}
+
+// setDeviceReadOnly marks the given block device as read only
+func (ns *node) setDeviceReadOnly(devicePath string) error {
+	logrus.Infof("NodeStageVolume: setting device: {%s} read only", devicePath)
+	out, err := ns.mounter.Exec.Command("blockdev", "--setro", devicePath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to set device: {%s} read only, err: {%v}, output: {%s}", devicePath, err, string(out))
+	}
+	return nil
+}
+
+func (ns *node) formatAndMount(req *csi.NodeStageVolumeRequest, devicePath string, readOnly bool) error {
 	// Mount device
 	mntPath := req.GetStagingTargetPath()
 	notMnt, err := ns.mounter.IsLikelyNotMountPoint(mntPath)
@@ -345,6 +638,27 @@ func (ns *node) formatAndMount(req *csi.NodeStageVolumeRequest, devicePath strin
 	mountFlags := req.GetVolumeCapability().GetMount().GetMountFlags()
 	options = append(options, mountFlags...)
 
+	if readOnly {
+		// read only devices must never be formatted, since
+		// they are expected to hold the data to be read
+		existingFormat, err := ns.mounter.GetDiskFormat(devicePath)
+		if err != nil {
+			return err
+		}
+		if existingFormat == "" {
+			return fmt.Errorf("refusing to format read only device: {%s} of volume: {%s}", devicePath, req.GetVolumeId())
+		}
+
+		// skip journal recovery, since device is read only
+		options = append(options, "ro")
+		switch existingFormat {
+		case FSTypeExt3, FSTypeExt4:
+			options = append(options, "noload")
+		case FSTypeXfs:
+			options = append(options, "norecovery")
+		}
+	}
+
 	err = ns.mounter.FormatAndMount(devicePath, mntPath, fsType, options)
 	if err != nil {
 		logrus.Errorf(
@@ -385,37 +699,53 @@ func (ns *node) NodePublishVolume(
 	}
 
 	logrus.Infof("NodePublishVolume: start publishing volume: {%q}", volumeID)
-	if err := request.AddVolumeToTransitionList(volumeID, "NodePublishVolume"); err != nil {
-		return nil, status.Error(codes.Aborted, err.Error())
+	unlock, err := ns.driver.lockVolume(volumeID, "NodePublishVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	mountOptions := []string{"bind"}
+	if req.GetReadonly() || isReadOnlyAccessMode(volCap) {
+		mountOptions = append(mountOptions, "ro")
 	}
 
-	defer request.RemoveVolumeFromTransitionList(volumeID)
+	if isNFSVolume(req.GetVolumeContext()) {
+		if err := ns.nodePublishVolumeForNFS(req, mountOptions); err != nil {
+			return nil, err
+		}
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
 
 	// Volume may be mounted at targetPath (bind mount in NodePublish)
 	if err := ns.isAlreadyMounted(volumeID, target); err != nil {
 		return nil, err
 	}
-
-	mountOptions := []string{"bind"}
-	if req.GetReadonly() {
-		mountOptions = append(mountOptions, "ro")
+	instance, err := doesVolumeExist(ctx, volumeID, ns.client)
+	if err != nil {
+		return nil, err
 	}
+
 	switch mode := volCap.GetAccessType().(type) {
 	case *csi.VolumeCapability_Block:
-		return &csi.NodePublishVolumeResponse{}, status.Error(codes.Unimplemented, "doesn't support block device provisioning")
+		if err := ns.nodePublishVolumeForBlock(req, mountOptions, instance); err != nil {
+			return nil, err
+		}
 	case *csi.VolumeCapability_Mount:
 		if err := ns.nodePublishVolumeForFileSystem(req, mountOptions, mode); err != nil {
 			return nil, err
 		}
 	}
 
-	instance, err := doesVolumeExist(volumeID, ns.client)
-	if err != nil {
-		return nil, err
+	// read only volumes are published on multiple nodes,
+	// so the target path of a single node is not recorded
+	if _, ok := getReadOnlyAttachments(instance)[ns.driver.config.NodeID]; ok {
+		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
-	instance.Spec.MountInfo.TargetPath = target
-	if err := ns.client.UpdateJivaVolume(instance); err != nil {
+	if err := ns.client.PatchMountInfo(ctx, instance, client.MountInfoPatch{
+		TargetPath: pointer.StringPtr(target),
+	}); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
@@ -468,6 +798,38 @@ func (ns *node) nodePublishVolumeForFileSystem(req *csi.NodePublishVolumeRequest
 	return nil
 }
 
+func (ns *node) nodePublishVolumeForBlock(req *csi.NodePublishVolumeRequest, mountOptions []string, instance *jv.JivaVolume) error {
+	target := req.GetTargetPath()
+	source, fsType := getStagedDevice(instance, ns.driver.config.NodeID)
+	if fsType != blockFsType || source == "" {
+		return status.Errorf(codes.FailedPrecondition, "Volume {%q} is not staged as block device", req.GetVolumeId())
+	}
+
+	// target path of the block volume is a file which is
+	// bind mounted to the device
+	targetDir := filepath.Dir(target)
+	logrus.Infof("NodePublishVolume: creating dir: {%s}", targetDir)
+	if err := os.MkdirAll(targetDir, 0750); err != nil {
+		return status.Errorf(codes.Internal, "Could not create dir {%q}, err: %v", targetDir, err)
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE, 0660)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Could not create file {%q}, err: %v", target, err)
+	}
+	file.Close()
+
+	logrus.Infof("NodePublishVolume: start mounting: block device: {%s} at target: {%s} with options: {%s}", source, target, mountOptions)
+	if err := ns.mounter.Mount(source, target, "", mountOptions); err != nil {
+		if removeErr := os.Remove(target); removeErr != nil {
+			return status.Errorf(codes.Internal, "Could not remove mount target %q: %v", target, removeErr)
+		}
+		return status.Errorf(codes.Internal, "Could not mount %q at %q: %v", source, target, err)
+	}
+
+	return nil
+}
+
 // NodeUnpublishVolume unpublishes (unmounts) the volume
 // from the corresponding node from the given path
 //
@@ -487,23 +849,41 @@ func (ns *node) NodeUnpublishVolume(
 		return nil, status.Error(codes.InvalidArgument, "Target path not provided")
 	}
 
-	if err := request.AddVolumeToTransitionList(volumeID, "NodeUnPublishVolume"); err != nil {
-		return nil, status.Error(codes.Aborted, err.Error())
+	unlock, err := ns.driver.lockVolume(volumeID, "NodeUnPublishVolume")
+	if err != nil {
+		return nil, err
 	}
-
-	defer request.RemoveVolumeFromTransitionList(volumeID)
+	defer unlock()
 
 	if err := ns.unmount(volumeID, target); err != nil {
 		return nil, err
 	}
 
-	instance, err := doesVolumeExist(volumeID, ns.client)
-	if err != nil {
+	// target path of the raw block volume is a file
+	// created during NodePublishVolume
+	if info, err := os.Stat(target); err == nil && !info.IsDir() {
+		if err := os.Remove(target); err != nil {
+			return nil, status.Errorf(codes.Internal, "Could not remove target %q: %v", target, err)
+		}
+	}
+
+	// volumes served via NFS gateway don't have
+	// the JivaVolume with the same name
+	instance, err := doesVolumeExist(ctx, volumeID, ns.client)
+	if err != nil && status.Code(err) == codes.NotFound {
+		logrus.Infof("NodeUnpublishVolume: volume: {%s} is unmounted from {%s}", volumeID, target)
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	} else if err != nil {
 		return nil, err
 	}
 
-	instance.Spec.MountInfo.TargetPath = ""
-	if err := ns.client.UpdateJivaVolume(instance); err != nil {
+	if _, ok := getReadOnlyAttachments(instance)[ns.driver.config.NodeID]; ok {
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+
+	if err := ns.client.PatchMountInfo(ctx, instance, client.MountInfoPatch{
+		TargetPath: pointer.StringPtr(""),
+	}); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
@@ -559,11 +939,44 @@ func (ns *node) NodeGetInfo(
 	req *csi.NodeGetInfoRequest,
 ) (*csi.NodeGetInfoResponse, error) {
 
+	// controller plugin records the initiator of the node on the
+	// volume while publishing it, it is only advisory so the node
+	// is registered even if the initiator name can't be set
+	ns.setNodeInitiatorIQN(ctx)
+
 	return &csi.NodeGetInfoResponse{
-		NodeId: ns.driver.config.NodeID,
+		NodeId:            ns.driver.config.NodeID,
+		MaxVolumesPerNode: ns.driver.config.MaxVolumesPerNode,
 	}, nil
 }
 
+// setNodeInitiatorIQN sets the initiator name of the node on the node
+// object, the failures are logged since the node can be registered
+// without it
+func (ns *node) setNodeInitiatorIQN(ctx context.Context) {
+	iqn, err := getInitiatorIQN()
+	if err != nil {
+		logrus.Warningf("NodeGetInfo: failed to get initiator name, err: {%v}", err)
+		return
+	}
+
+	node, err := ns.client.GetNode(ctx, ns.driver.config.NodeID)
+	if err != nil {
+		logrus.Warningf("NodeGetInfo: failed to get node: {%s}, err: {%v}", ns.driver.config.NodeID, err)
+		return
+	}
+
+	if node.Annotations[jivavolume.NodeInitiatorIQNKey] == iqn {
+		return
+	}
+
+	if err := ns.client.PatchNodeAnnotations(ctx, node, map[string]*string{
+		jivavolume.NodeInitiatorIQNKey: pointer.StringPtr(iqn),
+	}); err != nil {
+		logrus.Warningf("NodeGetInfo: failed to set initiator name of node: {%s}, err: {%v}", node.Name, err)
+	}
+}
+
 // NodeGetCapabilities returns capabilities supported
 // by this node service
 //
@@ -587,9 +1000,6 @@ func (ns *node) NodeGetCapabilities(
 	return &csi.NodeGetCapabilitiesResponse{Capabilities: caps}, nil
 }
 
-// TODO
-// Verify if this needs to be implemented
-//
 // NodeExpandVolume resizes the filesystem if required
 //
 // If ControllerExpandVolumeResponse returns true in
@@ -612,11 +1022,11 @@ func (ns *node) NodeExpandVolume(
 		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats Volume Path must be provided")
 	}
 
-	if err := request.AddVolumeToTransitionList(volumeID, "NodeExpandVolume"); err != nil {
-		return nil, status.Error(codes.Aborted, err.Error())
+	unlock, err := ns.driver.lockVolume(volumeID, "NodeExpandVolume")
+	if err != nil {
+		return nil, err
 	}
-
-	defer request.RemoveVolumeFromTransitionList(volumeID)
+	defer unlock()
 
 	mounted, err := ns.mounter.ExistsPath(volumePath)
 	if err != nil {
@@ -628,31 +1038,65 @@ func (ns *node) NodeExpandVolume(
 	}
 
 	// JivaVolume CR may be updated by jiva-operator
-	instance, err := ns.doesVolumeExist(volumeID)
+	instance, err := ns.doesVolumeExist(ctx, volumeID)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := ns.resizeVolume(ctx, instance, volumePath); err != nil {
+		ns.client.WarningEventf(instance, client.ReasonResizeFailed,
+			"Failed to resize volume on node %s: %v", ns.driver.config.NodeID, err)
+		return nil, err
+	}
+	ns.client.NormalEventf(instance, client.ReasonResized,
+		"Resized volume on node %s", ns.driver.config.NodeID)
+
+	return &csi.NodeExpandVolumeResponse{
+		CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+	}, nil
+}
+
+// resizeVolume rescans the iSCSI sessions of the volume and resizes the
+// multipath device, then the dm-crypt mapping on top of it and the
+// filesystem mounted at the given path
+func (ns *node) resizeVolume(ctx context.Context, instance *jv.JivaVolume, volumePath string) error {
 	resize := resizeInput{
-		volumePath:   volumePath,
-		fsType:       instance.Spec.MountInfo.FSType,
-		iqn:          instance.Spec.ISCSISpec.Iqn,
-		targetPortal: instance.Spec.ISCSISpec.TargetIP,
-		exec:         ns.mounter.Exec,
+		volumePath:    volumePath,
+		fsType:        instance.Spec.MountInfo.FSType,
+		iqn:           instance.Spec.ISCSISpec.Iqn,
+		targetPortals: getTargetPortals(instance),
+		exec:          ns.mounter.Exec,
 	}
 
-	list, err := ns.mounter.List()
+	encrypted, err := isCryptMappingOpen(instance.Name)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return status.Error(codes.Internal, err.Error())
+	}
+	if encrypted {
+		resize.cryptName = getCryptMappingName(instance.Name)
 	}
 
-	if err := resize.volume(list); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	// there is no filesystem on the raw block volume,
+	// so only the iSCSI session needs to be rescanned
+	if instance.Spec.MountInfo.FSType == blockFsType {
+		if err := resize.reScan(ctx); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if err := resize.resizeCrypt(); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		return nil
 	}
 
-	return &csi.NodeExpandVolumeResponse{
-		CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
-	}, nil
+	list, err := ns.mounter.List()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if err := resize.volume(ctx, list); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
 }
 
 // NodeGetVolumeStats returns statistics for the
@@ -683,16 +1127,102 @@ func (ns *node) NodeGetVolumeStats(
 		return nil, status.Errorf(codes.NotFound, "Volume path {%q} is not mounted", volumePath)
 	}
 
+	condition := ns.getVolumeCondition(ctx, volumeID, volumePath)
+
+	isBlock, err := isBlockDevice(volumePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to check if volume path {%q} is a block device: %s", volumePath, err)
+	}
+
+	if isBlock {
+		stats, err := getBlockStatistics(ns.mounter.Exec, volumePath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to retrieve capacity statistics for block device {%q}: {%s}", volumePath, err)
+		}
+		return &csi.NodeGetVolumeStatsResponse{
+			Usage:           stats,
+			VolumeCondition: condition,
+		}, nil
+	}
+
 	stats, err := getStatistics(volumePath)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Failed to retrieve capacity statistics for volume path {%q}: {%s}", volumePath, err)
 	}
 
 	return &csi.NodeGetVolumeStatsResponse{
-		Usage: stats,
+		Usage:           stats,
+		VolumeCondition: condition,
 	}, nil
 }
 
+// getVolumeCondition returns the condition of the volume from its
+// JivaVolume status, the iSCSI paths and the mount state at the given
+// path, failure to get the condition doesn't fail the stats of the volume
+func (ns *node) getVolumeCondition(ctx context.Context, volumeID, volumePath string) *csi.VolumeCondition {
+	var problems []string
+	instance, err := ns.doesVolumeExist(ctx, volumeID)
+	switch {
+	case err == nil:
+		problems = append(getVolumeProblems(instance), ns.getPathProblems(ctx, instance)...)
+	case status.Code(err) == codes.NotFound:
+		// volume may be served via NFS gateway, only
+		// the mount state of the volume can be verified
+		instance = nil
+	default:
+		logrus.Warningf("NodeGetVolumeStats: failed to get volume: {%s}, err: {%v}", volumeID, err)
+		return nil
+	}
+
+	problems = append(problems, ns.getMountProblems(instance, volumePath)...)
+	if len(problems) != 0 {
+		logrus.Warningf("NodeGetVolumeStats: volume: {%s} is abnormal, problems: {%v}", volumeID, problems)
+	}
+	return newVolumeCondition(problems)
+}
+
+// getPathProblems returns the failed iSCSI paths of the volume
+func (ns *node) getPathProblems(ctx context.Context, instance *jv.JivaVolume) []string {
+	paths, err := getPathStatus(ctx, ns.mounter.Exec, instance.Spec.ISCSISpec.Iqn, getTargetPortals(instance))
+	if err != nil {
+		logrus.Warningf("NodeGetVolumeStats: failed to get path status of volume: {%s}, err: {%v}", instance.Name, err)
+		return nil
+	}
+
+	if paths.degraded() {
+		return []string{fmt.Sprintf("iSCSI paths %v have failed", paths.failed)}
+	}
+	logrus.Debugf("NodeGetVolumeStats: volume: {%s} paths: {%s}", instance.Name, paths)
+	return nil
+}
+
+// getMountProblems verifies that the filesystem of the volume is mounted
+// in rw mode at the given path unless the volume is read only
+func (ns *node) getMountProblems(instance *jv.JivaVolume, volumePath string) []string {
+	if instance != nil &&
+		(instance.Spec.MountInfo.FSType == blockFsType ||
+			instance.Annotations[jivavolume.ReadOnlyKey] == "true") {
+		return nil
+	}
+
+	mountList, err := ns.mounter.List()
+	if err != nil {
+		logrus.Warningf("NodeGetVolumeStats: failed to list mount points, err: {%v}", err)
+		return nil
+	}
+
+	mountPoint, ok := listContains(volumePath, mountList)
+	if !ok {
+		// raw block volumes are bind mounted files, which
+		// are not always listed with the volume path
+		return nil
+	}
+	if !verifyMountOpts(mountPoint.Opts, "rw") {
+		return []string{fmt.Sprintf("volume is mounted read only at %s", volumePath)}
+	}
+	return nil
+}
+
 func (ns *node) validateNodePublishReq(
 	req *csi.NodePublishVolumeRequest,
 ) error {
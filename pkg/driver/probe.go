@@ -0,0 +1,129 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// probeCacheTTL is the duration for which the result of the
+	// readiness checks is reused by the subsequent probes
+	probeCacheTTL = 30 * time.Second
+
+	// monitorMountsStallTimeout is the duration after which
+	// MonitorMounts is considered to be stalled if it has not
+	// started a new monitoring attempt
+	monitorMountsStallTimeout = 10 * MonitorMountRetryTimeout * time.Second
+
+	// probeCheckTimeout is the timeout of the API calls made by the
+	// readiness checks, which are not bound to the context of Probe
+	// since their result is cached
+	probeCheckTimeout = 10 * time.Second
+)
+
+// nodeCommands are the commands required by the node plugin,
+// along with the args to check that they can be run
+var nodeCommands = [][]string{
+	{"iscsiadm", "--version"},
+	{"mount", "--version"},
+}
+
+// readinessCheck returns the reason if the plugin is not ready
+type readinessCheck func() error
+
+// readiness caches the result of the readiness checks of the plugin
+type readiness struct {
+	checks []readinessCheck
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+func newReadiness(checks ...readinessCheck) *readiness {
+	return &readiness{checks: checks}
+}
+
+// check runs the readiness checks if the cached result has expired,
+// it returns the reason if the plugin is not ready
+func (r *readiness) check() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.checkedAt.IsZero() && time.Since(r.checkedAt) < probeCacheTTL {
+		return r.err
+	}
+
+	var err error
+	for _, check := range r.checks {
+		if err = check(); err != nil {
+			break
+		}
+	}
+
+	if err != nil && (r.err == nil || r.err.Error() != err.Error()) {
+		logrus.Warningf("Probe: plugin is not ready, reason: {%v}", err)
+	} else if err == nil && r.err != nil {
+		logrus.Infof("Probe: plugin is ready")
+	}
+	r.err, r.checkedAt = err, time.Now()
+	return err
+}
+
+// checkJivaVolumeAccess checks that the controller plugin can list
+// the JivaVolumes from the API server
+func checkJivaVolumeAccess(cli *client.Client) readinessCheck {
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), probeCheckTimeout)
+		defer cancel()
+		if err := cli.CheckJivaVolumeAccess(ctx); err != nil {
+			return fmt.Errorf("failed to list JivaVolumes, err: {%v}", err)
+		}
+		return nil
+	}
+}
+
+// checkNodeCommands checks that the commands required by
+// the node plugin to attach and mount the volumes can be run
+func checkNodeCommands(exec utilexec.Interface) readinessCheck {
+	return func() error {
+		for _, cmd := range nodeCommands {
+			if out, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to run %s, err: {%v}, output: {%s}", cmd[0], err, out)
+			}
+		}
+		return nil
+	}
+}
+
+// checkMonitorMounts checks that MonitorMounts has
+// not stalled on any of its monitoring attempts
+func checkMonitorMounts(nm *NodeMounter) readinessCheck {
+	return func() error {
+		if last := nm.lastMonitored(); time.Since(last) > monitorMountsStallTimeout {
+			return fmt.Errorf("MonitorMounts has stalled since {%v}", last.Format(time.RFC3339))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// initiatorNameFile contains the iSCSI initiator name of the node
+	initiatorNameFile = "/etc/iscsi/initiatorname.iscsi"
+
+	// initiatorIQNKey is the publish context key for the initiator
+	// IQN of the node to which the volume is published
+	initiatorIQNKey = "initiatorIQN"
+)
+
+// getInitiatorIQN returns the iSCSI initiator name of the node
+func getInitiatorIQN() (string, error) {
+	f, err := os.Open(initiatorNameFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "InitiatorName=") {
+			return strings.TrimPrefix(line, "InitiatorName="), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("initiator name not found in {%s}", initiatorNameFile)
+}
+
+// getPublishedNode returns the node and the initiator IQN to which
+// the volume is published via ControllerPublishVolume
+func getPublishedNode(instance *jv.JivaVolume) (string, string) {
+	return instance.Annotations[jivavolume.PublishedNodeKey],
+		instance.Annotations[jivavolume.InitiatorIQNKey]
+}
+
+// publishedNodeAnnotations returns the annotations which record the node
+// and initiator IQN to which the volume is published, the annotations
+// are removed if the node is empty
+func publishedNodeAnnotations(nodeID, iqn string) map[string]*string {
+	annotations := map[string]*string{
+		jivavolume.PublishedNodeKey: nil,
+		jivavolume.InitiatorIQNKey:  nil,
+	}
+	if nodeID == "" {
+		return annotations
+	}
+
+	annotations[jivavolume.PublishedNodeKey] = pointer.StringPtr(nodeID)
+	if iqn != "" {
+		annotations[jivavolume.InitiatorIQNKey] = pointer.StringPtr(iqn)
+	}
+	return annotations
+}
+
+// verifyPublishedNode checks that the volume is published to this node
+// before logging in to the target. It is a best effort check of the node
+// plugin, the sessions which are already logged in are not torn down and
+// the target accepts logins from any initiator. Volumes which are not
+// published are not checked, since multi node access modes are not
+// published to a single node
+func (ns *node) verifyPublishedNode(instance *jv.JivaVolume) error {
+	nodeID, iqn := getPublishedNode(instance)
+	if nodeID == "" {
+		return nil
+	}
+
+	if nodeID != ns.driver.config.NodeID {
+		return status.Errorf(codes.FailedPrecondition,
+			"NodeStageVolume: volume: {%s} is published to node: {%s}, not to this node: {%s}",
+			instance.Name, nodeID, ns.driver.config.NodeID)
+	}
+
+	if iqn == "" {
+		return nil
+	}
+
+	localIQN, err := getInitiatorIQN()
+	if err != nil {
+		return status.Errorf(codes.Internal, "NodeStageVolume: failed to get initiator name, err: {%v}", err)
+	}
+
+	if localIQN != iqn {
+		return status.Errorf(codes.FailedPrecondition,
+			"NodeStageVolume: volume: {%s} is published to initiator: {%s}, not to this initiator: {%s}",
+			instance.Name, iqn, localIQN)
+	}
+	logrus.Debugf("NodeStageVolume: volume: {%s} is published to initiator: {%s}", instance.Name, iqn)
+	return nil
+}
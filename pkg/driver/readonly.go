@@ -0,0 +1,97 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+)
+
+// readOnlyAttachment is the attachment of a read only volume on a node.
+// Read only volumes are staged on multiple nodes, so their attachments
+// are kept in an annotation per node instead of the nodeID label and
+// the mount info of the JivaVolume which belong to a single node
+type readOnlyAttachment struct {
+	NodeID      string `json:"nodeID"`
+	DevicePath  string `json:"devicePath"`
+	StagingPath string `json:"stagingPath"`
+	FSType      string `json:"fsType"`
+}
+
+// getReadOnlyAttachmentKey returns the annotation key of the read only
+// attachment on the given node, the node ID is hashed since the name
+// part of the key is limited to 63 characters
+func getReadOnlyAttachmentKey(nodeID string) string {
+	h := fnv.New64a()
+	h.Write([]byte(nodeID))
+	return fmt.Sprintf("%s%x", jivavolume.ReadOnlyNodeKeyPrefix, h.Sum64())
+}
+
+// getReadOnlyAttachmentValue returns the annotation value
+// of the given read only attachment
+func getReadOnlyAttachmentValue(attachment readOnlyAttachment) (string, error) {
+	data, err := json.Marshal(attachment)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// getReadOnlyAttachments returns the read only attachments of
+// the given volume by node, invalid annotations are ignored
+func getReadOnlyAttachments(instance *jv.JivaVolume) map[string]readOnlyAttachment {
+	attachments := map[string]readOnlyAttachment{}
+	for key, value := range instance.Annotations {
+		if !strings.HasPrefix(key, jivavolume.ReadOnlyNodeKeyPrefix) {
+			continue
+		}
+
+		attachment := readOnlyAttachment{}
+		if err := json.Unmarshal([]byte(value), &attachment); err != nil || attachment.NodeID == "" {
+			logrus.Warningf("Ignoring invalid read only attachment: {%s: %s} of volume: {%s}, err: {%v}",
+				key, value, instance.Name, err)
+			continue
+		}
+		attachments[attachment.NodeID] = attachment
+	}
+	return attachments
+}
+
+// getStagedDevice returns the device path and the fs type of the
+// given volume staged on the given node
+func getStagedDevice(instance *jv.JivaVolume, nodeID string) (string, string) {
+	if attachment, ok := getReadOnlyAttachments(instance)[nodeID]; ok {
+		return attachment.DevicePath, attachment.FSType
+	}
+	return instance.Spec.MountInfo.DevicePath, instance.Spec.MountInfo.FSType
+}
+
+// isAttachedToNode checks if the given volume
+// is staged on the given node
+func isAttachedToNode(instance *jv.JivaVolume, nodeID string) bool {
+	if instance.Labels["nodeID"] == nodeID {
+		return true
+	}
+	_, ok := getReadOnlyAttachments(instance)[nodeID]
+	return ok
+}
@@ -18,25 +18,32 @@ package driver
 
 import (
 	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
 	utilexec "k8s.io/utils/exec"
 	"k8s.io/utils/mount"
 )
 
 type resizeInput struct {
-	volumePath   string
-	fsType       string
-	iqn          string
-	targetPortal string
-	exec         utilexec.Interface
+	volumePath    string
+	fsType        string
+	iqn           string
+	targetPortals []string
+	// cryptName is the name of the dm-crypt mapping
+	// if the volume is encrypted
+	cryptName string
+	exec      utilexec.Interface
 }
 
-func (r resizeInput) volume(list []mount.MountPoint) error {
+func (r resizeInput) volume(ctx context.Context, list []mount.MountPoint) error {
 	for _, mpt := range list {
 		if mpt.Path == r.volumePath {
-			err := r.reScan()
+			err := r.reScan(ctx)
 			if err != nil {
 				return err
 			}
+			if err := r.resizeCrypt(); err != nil {
+				return err
+			}
 			switch r.fsType {
 			case "ext4":
 				err = r.resizeExt4(mpt.Device)
@@ -52,12 +59,47 @@ func (r resizeInput) volume(list []mount.MountPoint) error {
 	return nil
 }
 
-// ReScan rescans all the iSCSI sessions on the host
-func (r resizeInput) reScan() error {
-	logrus.Info("Rescan ISCSI session")
-	out, err := r.exec.Command("iscsiadm", "-m", "node", "-T", r.iqn, "-P", r.targetPortal, "--rescan").CombinedOutput()
+// ReScan rescans the iSCSI sessions of all the paths of the volume,
+// and resizes the multipath device if the volume has multiple paths.
+// The multipath map is looked up from the iSCSI devices, since the
+// device path of an encrypted volume is its dm-crypt mapping
+func (r resizeInput) reScan(ctx context.Context) error {
+	for _, portal := range r.targetPortals {
+		logrus.Infof("Rescan ISCSI session of portal: %s", portal)
+		out, err := r.exec.CommandContext(ctx, "iscsiadm", "-m", "node", "-T", r.iqn, "-p", portal, "--rescan").CombinedOutput()
+		if err != nil {
+			logrus.Errorf("iscsi: rescan failed error: %s", string(out))
+			return err
+		}
+	}
+
+	mpath, err := getMultipathMap(r.iqn, r.targetPortals)
+	if err != nil {
+		return err
+	}
+	if mpath == "" {
+		return nil
+	}
+
+	logrus.Infof("Resize multipath device: %s", mpath)
+	out, err := r.exec.Command("multipathd", "resize", "map", mpath).CombinedOutput()
+	if err != nil {
+		logrus.Errorf("multipath: resize failed error: %s", string(out))
+		return err
+	}
+	return nil
+}
+
+// resizeCrypt resizes the dm-crypt mapping of the encrypted volume
+// to the size of the underlying device
+func (r resizeInput) resizeCrypt() error {
+	if r.cryptName == "" {
+		return nil
+	}
+	logrus.Infof("Resize crypt mapping: %s", r.cryptName)
+	out, err := r.exec.Command("cryptsetup", "resize", r.cryptName).CombinedOutput()
 	if err != nil {
-		logrus.Errorf("iscsi: rescan failed error: %s", string(out))
+		logrus.Errorf("cryptsetup: resize failed error: %s", string(out))
 		return err
 	}
 	return nil
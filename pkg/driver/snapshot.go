@@ -0,0 +1,215 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/openebs/jiva-operator/pkg/volume"
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// snapshotIDSeparator separates the volume name and snapshot name
+	// in the snapshot ID i.e. <volume>@<snapshot>
+	snapshotIDSeparator = "@"
+
+	// snapshotDiskPrefix and snapshotDiskSuffix are used by jiva
+	// replicas to name the snapshot files in the replica chain
+	snapshotDiskPrefix = "volume-snap-"
+	snapshotDiskSuffix = ".img"
+)
+
+// snapshotInput is the input for the snapshot and
+// deleteSnapshot actions of jiva controller
+type snapshotInput struct {
+	volume.Resource
+	Name        string `json:"name"`
+	UserCreated bool   `json:"usercreated"`
+	Created     string `json:"created"`
+}
+
+// snapshotOutput is the response of snapshot action
+// of jiva controller
+type snapshotOutput struct {
+	volume.Resource
+}
+
+// diskInfo is the info of a disk (head or snapshot)
+// in the replica chain
+type diskInfo struct {
+	Name        string `json:"name"`
+	Parent      string `json:"parent"`
+	Removed     bool   `json:"removed"`
+	UserCreated bool   `json:"usercreated"`
+	Created     string `json:"created"`
+	Size        string `json:"size"`
+}
+
+// replicaInfo is the info served by jiva replica
+type replicaInfo struct {
+	volume.Resource
+	Chain []string            `json:"chain"`
+	Disks map[string]diskInfo `json:"disks"`
+}
+
+// snapshotInfo contains the details of a snapshot
+// of a jiva volume
+type snapshotInfo struct {
+	name    string
+	created time.Time
+}
+
+// getSnapshotID returns the CSI snapshot ID for the given
+// volume and snapshot name
+func getSnapshotID(volumeID, snapName string) string {
+	return volumeID + snapshotIDSeparator + snapName
+}
+
+// parseSnapshotID returns the volume name and snapshot
+// name from the given CSI snapshot ID
+func parseSnapshotID(snapshotID string) (string, string, error) {
+	parts := strings.Split(snapshotID, snapshotIDSeparator)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid snapshot ID {%s}, expected <volume>%s<snapshot>",
+			snapshotID, snapshotIDSeparator)
+	}
+	return parts[0], parts[1], nil
+}
+
+// listSnapshots returns the user created snapshots of the given volume,
+// the snapshot chain is fetched from the first replica which is in RW mode
+// since the chain is same across all the healthy replicas
+func listSnapshots(ctx context.Context, instance *jv.JivaVolume) (map[string]snapshotInfo, error) {
+	var address string
+	for _, rep := range instance.Status.ReplicaStatuses {
+		if rep.Mode == "RW" {
+			address = rep.Address
+			break
+		}
+	}
+
+	if address == "" {
+		return nil, fmt.Errorf("no healthy replica found for volume {%s}", instance.Name)
+	}
+
+	info := replicaInfo{}
+	if err := replicaClient(address).do(ctx, http.MethodGet, "/replicas/1", nil, &info); err != nil {
+		return nil, fmt.Errorf("Failed to get replica info from {%s}, err: %v", address, err)
+	}
+
+	snaps := map[string]snapshotInfo{}
+	for _, disk := range info.Disks {
+		if disk.Removed || !disk.UserCreated ||
+			!strings.HasPrefix(disk.Name, snapshotDiskPrefix) {
+			continue
+		}
+		name := strings.TrimSuffix(
+			strings.TrimPrefix(disk.Name, snapshotDiskPrefix),
+			snapshotDiskSuffix,
+		)
+		created, _ := time.Parse(time.RFC3339, disk.Created)
+		snaps[name] = snapshotInfo{name: name, created: created}
+	}
+	return snaps, nil
+}
+
+// createSnapshot takes the snapshot of the given volume if it
+// doesn't exist already
+func createSnapshot(ctx context.Context, instance *jv.JivaVolume, snapName string) (snapshotInfo, error) {
+	snaps, err := listSnapshots(ctx, instance)
+	if err != nil {
+		return snapshotInfo{}, err
+	}
+
+	if snap, ok := snaps[snapName]; ok {
+		return snap, nil
+	}
+
+	cli, err := newJivaController(instance)
+	if err != nil {
+		return snapshotInfo{}, err
+	}
+
+	created := time.Now().UTC()
+	input := snapshotInput{
+		Name:        snapName,
+		UserCreated: true,
+		Created:     created.Format(time.RFC3339),
+	}
+	// snapshot is not retried here, the retry of CreateSnapshot
+	// finds the snapshot if the earlier request had created it
+	if err := cli.doAction(ctx, "snapshot", input, &snapshotOutput{}, false); err != nil {
+		return snapshotInfo{}, err
+	}
+	return snapshotInfo{name: snapName, created: created}, nil
+}
+
+// deleteSnapshot deletes the given snapshot of the volume,
+// deletion is ignored if the snapshot doesn't exist
+func deleteSnapshot(ctx context.Context, instance *jv.JivaVolume, snapName string) error {
+	snaps, err := listSnapshots(ctx, instance)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := snaps[snapName]; !ok {
+		return nil
+	}
+
+	cli, err := newJivaController(instance)
+	if err != nil {
+		return err
+	}
+
+	return cli.doAction(ctx, "deleteSnapshot", snapshotInput{Name: snapName}, nil, true)
+}
+
+// newCSISnapshot converts the snapshot of the given volume
+// into the CSI snapshot
+func newCSISnapshot(instance *jv.JivaVolume, snap snapshotInfo) (*csi.Snapshot, error) {
+	var sizeBytes int64
+	if instance.Spec.Capacity != "" {
+		capacity, err := resource.ParseQuantity(instance.Spec.Capacity)
+		if err != nil {
+			return nil, err
+		}
+		sizeBytes = capacity.Value()
+	}
+
+	// snapshot of NFS gateway backend belongs to the gateway volume
+	sourceVolumeID := instance.Name
+	if volumeID := instance.Annotations[jivavolume.NFSGatewayVolumeKey]; volumeID != "" {
+		sourceVolumeID = volumeID
+	}
+
+	return &csi.Snapshot{
+		SnapshotId:     getSnapshotID(instance.Name, snap.name),
+		SourceVolumeId: sourceVolumeID,
+		SizeBytes:      sizeBytes,
+		CreationTime:   timestamppb.New(snap.created),
+		ReadyToUse:     true,
+	}, nil
+}
@@ -0,0 +1,42 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("parseSnapshotID",
+	func(snapshotID, volumeID, snapName string, valid bool) {
+		vol, snap, err := parseSnapshotID(snapshotID)
+		if !valid {
+			Expect(err).Should(HaveOccurred())
+			return
+		}
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(vol).Should(Equal(volumeID))
+		Expect(snap).Should(Equal(snapName))
+		Expect(getSnapshotID(vol, snap)).Should(Equal(snapshotID))
+	},
+	Entry("valid snapshot ID", "pvc-1@snapshot-1", "pvc-1", "snapshot-1", true),
+	Entry("missing separator", "pvc-1", "", "", false),
+	Entry("missing volume", "@snapshot-1", "", "", false),
+	Entry("missing snapshot", "pvc-1@", "", "", false),
+	Entry("multiple separators", "pvc-1@snap@1", "", "", false),
+	Entry("empty snapshot ID", "", "", "", false),
+)
@@ -17,8 +17,13 @@ limitations under the License.
 package driver
 
 import (
+	"os"
+	"strconv"
+	"strings"
+
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/sys/unix"
+	utilexec "k8s.io/utils/exec"
 )
 
 func getStatistics(volumePath string) ([]*csi.VolumeUsage, error) {
@@ -52,3 +57,34 @@ func getStatistics(volumePath string) ([]*csi.VolumeUsage, error) {
 	}
 	return volStats, nil
 }
+
+// isBlockDevice checks if the given path is a block device
+// or a file bind mounted to a raw block volume
+func isBlockDevice(volumePath string) (bool, error) {
+	info, err := os.Stat(volumePath)
+	if err != nil {
+		return false, err
+	}
+	return info.Mode()&os.ModeDevice != 0 || !info.IsDir(), nil
+}
+
+// getBlockStatistics returns the size of the raw block volume, used
+// and available bytes are not known since there is no filesystem
+func getBlockStatistics(exec utilexec.Interface, volumePath string) ([]*csi.VolumeUsage, error) {
+	out, err := exec.Command("blockdev", "--getsize64", volumePath).CombinedOutput()
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*csi.VolumeUsage{
+		&csi.VolumeUsage{
+			Total: size,
+			Unit:  csi.VolumeUsage_BYTES,
+		},
+	}, nil
+}
@@ -23,6 +23,32 @@ import (
 	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
 )
 
+const (
+	// ReadOnlyKey is the annotation key which is set to true by node
+	// plugin while the volume is staged as read only on any node
+	ReadOnlyKey = "openebs.io/read-only"
+	// ReadOnlyNodeKeyPrefix is the prefix of the annotation keys of the
+	// attachments of a read only volume, one annotation is set per node
+	// on which the volume is staged
+	ReadOnlyNodeKeyPrefix = "read-only.openebs.io/"
+	// PublishedNodeKey is the annotation key for the node to which
+	// the volume is published by ControllerPublishVolume
+	PublishedNodeKey = "openebs.io/published-node"
+	// InitiatorIQNKey is the annotation key for the iSCSI initiator
+	// of the node to which the volume is published
+	InitiatorIQNKey = "openebs.io/initiator-iqn"
+	// NodeInitiatorIQNKey is the annotation key set on the kubernetes
+	// node by node plugin with the iSCSI initiator name of the node
+	NodeInitiatorIQNKey = "openebs.io/iscsi-initiator"
+	// NFSGatewayVolumeKey is the annotation key for the ID of the volume
+	// served by the NFS gateway, it is set on the backend jiva volume
+	NFSGatewayVolumeKey = "openebs.io/nfs-gateway-volume"
+	// TargetPortalsKey is the annotation key for the comma separated
+	// additional portals of the jiva target used for multipath, it is
+	// not set by jiva-operator and must be set manually
+	TargetPortalsKey = "openebs.io/target-portals"
+)
+
 // Jiva wraps the JivaVolume structure
 type Jiva struct {
 	jvObj *jv.JivaVolume
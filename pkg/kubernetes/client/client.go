@@ -19,6 +19,8 @@ package client
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"sync"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/openebs/jiva-csi/pkg/jivavolume"
@@ -28,51 +30,74 @@ import (
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
 	"k8s.io/cloud-provider/volume/helpers"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 const (
-	defaultReplicaSC = "openebs-hostpath"
-	defaultNS        = "openebs"
-	defaultSizeBytes = 5 * helpers.GiB
+	defaultReplicaSC         = "openebs-hostpath"
+	defaultReplicationFactor = 3
+	defaultNS                = "openebs"
+	defaultSizeBytes         = 5 * helpers.GiB
 )
 
 // Client is the wrapper over the k8s client that will be used by
-// jiva-csi to interface with etcd
+// jiva-csi to interface with etcd. JivaVolumes are read from the cache
+// of the manager once it is started, the other resources are read from
+// the API server directly
 type Client struct {
-	cfg    *rest.Config
+	cfg *rest.Config
+	// client writes to the API server, and reads from it
+	// till the manager is started
 	client client.Client
+	// reader reads from the API server bypassing the cache
+	reader client.Reader
+	// cache holds the JivaVolumes watched by the manager
+	cache   cache.Cache
+	watcher *volumeWatcher
+	// events records the events of the volumes on their PV and PVC
+	events *eventRecorder
+
+	// written is the resource version of the JivaVolumes written by
+	// this client which is not yet observed in the cache, such volumes
+	// are read from the API server to read the latest writes
+	mu      sync.Mutex
+	written map[types.NamespacedName]string
 }
 
 // New creates a new client object using the given config
 func New(config *rest.Config) (*Client, error) {
 	c := &Client{
-		cfg: config,
+		cfg:     config,
+		watcher: newVolumeWatcher(),
+		written: map[types.NamespacedName]string{},
 	}
-	err := c.Set()
+	cl, err := client.New(config, client.Options{})
 	if err != nil {
 		return c, err
 	}
-	return c, nil
-}
+	c.client = cl
 
-// Set sets the client using the config
-func (cl *Client) Set() error {
-	c, err := client.New(cl.cfg, client.Options{})
+	events, err := newEventRecorder(config)
 	if err != nil {
-		return err
+		return c, err
 	}
-	cl.client = c
-	return nil
+	c.events = events
+	return c, nil
 }
 
-// RegisterAPI registers the API scheme in the client using the manager.
+// RegisterAPI registers the API scheme in the client using the manager,
+// and starts the manager so that JivaVolumes are served from its cache.
 // This function needs to be called only once a client object
 func (cl *Client) RegisterAPI(opts manager.Options) error {
 	mgr, err := manager.New(cl.cfg, opts)
@@ -84,13 +109,207 @@ func (cl *Client) RegisterAPI(opts manager.Options) error {
 	if err := apis.AddToScheme(mgr.GetScheme()); err != nil {
 		return err
 	}
+
+	// only the informer of JivaVolumes is started, reads of other
+	// resources bypass the cache so that they are not watched
+	if _, err := mgr.GetCache().GetInformer(&jv.JivaVolume{}); err != nil {
+		return err
+	}
+
+	c, err := client.New(cl.cfg, client.Options{
+		Scheme: mgr.GetScheme(),
+		Mapper: mgr.GetRESTMapper(),
+	})
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		if err := mgr.Start(stop); err != nil {
+			logrus.Fatalf("Failed to start manager, err: {%v}", err)
+		}
+	}()
+
+	if !mgr.GetCache().WaitForCacheSync(stop) {
+		return fmt.Errorf("failed to sync the cache of JivaVolumes")
+	}
+
+	cl.client = c
+	cl.reader = mgr.GetAPIReader()
+	cl.cache = mgr.GetCache()
+	return cl.watchWrites()
+}
+
+// watchWrites clears the written resource versions of the JivaVolumes as
+// soon as the cache observes them, so that the volumes which are not read
+// after the write are not kept track of
+func (cl *Client) watchWrites() error {
+	informer, err := cl.cache.GetInformer(&jv.JivaVolume{})
+	if err != nil {
+		return err
+	}
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if instance, ok := obj.(*jv.JivaVolume); ok {
+				cl.observed(instance)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if instance, ok := obj.(*jv.JivaVolume); ok {
+				cl.observed(instance)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if instance, ok := obj.(*jv.JivaVolume); ok {
+				cl.forget(instance)
+			}
+		},
+	})
+	return nil
+}
+
+// apiReader returns the reader which reads from the API server
+func (cl *Client) apiReader() client.Reader {
+	if cl.reader != nil {
+		return cl.reader
+	}
+	return cl.client
+}
+
+// volumeReader returns the reader of JivaVolumes
+func (cl *Client) volumeReader() client.Reader {
+	if cl.cache != nil {
+		return cl.cache
+	}
+	return cl.client
+}
+
+// setWritten records the resource version of the JivaVolume
+// written by this client
+func (cl *Client) setWritten(obj *jv.JivaVolume) {
+	if cl.cache == nil || obj.ResourceVersion == "" {
+		return
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.written[types.NamespacedName{Name: obj.Name, Namespace: obj.Namespace}] = obj.ResourceVersion
+}
+
+// observed clears the written resource version of the JivaVolume once
+// the cache has observed it, it returns false if the cache is stale
+func (cl *Client) observed(obj *jv.JivaVolume) bool {
+	key := types.NamespacedName{Name: obj.Name, Namespace: obj.Namespace}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	version, ok := cl.written[key]
+	if !ok {
+		return true
+	}
+	if isObservedVersion(obj.ResourceVersion, version) {
+		delete(cl.written, key)
+		return true
+	}
+	return false
+}
+
+// isObservedVersion checks if the cached resource version is the written
+// one or newer, the cache may skip the written version if the volume is
+// updated again by others before the watch event or on relist. Resource
+// versions are opaque, so they are compared only if both are integers as
+// set by etcd, otherwise the cache must have the written version
+func isObservedVersion(cached, written string) bool {
+	if cached == written {
+		return true
+	}
+	c, cerr := strconv.ParseUint(cached, 10, 64)
+	w, werr := strconv.ParseUint(written, 10, 64)
+	return cerr == nil && werr == nil && c > w
+}
+
+// getWritten returns the JivaVolumes written by this
+// client which are not yet observed in the cache
+func (cl *Client) getWritten() []types.NamespacedName {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	keys := make([]types.NamespacedName, 0, len(cl.written))
+	for key := range cl.written {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// forget clears the written resource version of the deleted JivaVolume
+func (cl *Client) forget(obj *jv.JivaVolume) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	delete(cl.written, types.NamespacedName{Name: obj.Name, Namespace: obj.Namespace})
+}
+
+// latest replaces the JivaVolumes which are stale in the cache with the
+// ones read from the API server. The volumes written by this client which
+// are missing in the cache, e.g. just created, are read from the API server
+// too, the volumes which don't match the given labels anymore are dropped
+func (cl *Client) latest(ctx context.Context, list *jv.JivaVolumeList, matchLabels map[string]string) error {
+	selector := labels.SelectorFromSet(matchLabels)
+	listed := map[types.NamespacedName]bool{}
+	items := list.Items[:0]
+	for i := range list.Items {
+		item := list.Items[i]
+		key := types.NamespacedName{Name: item.Name, Namespace: item.Namespace}
+		listed[key] = true
+		if !cl.observed(&item) {
+			logrus.Debugf("JivaVolume: {%v} is stale in cache, reading from API server", item.Name)
+			found, err := cl.getLatest(ctx, key, &item)
+			if err != nil {
+				return err
+			}
+			if !found || !selector.Matches(labels.Set(item.Labels)) {
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+
+	for _, key := range cl.getWritten() {
+		if listed[key] {
+			continue
+		}
+		item := jv.JivaVolume{}
+		found, err := cl.getLatest(ctx, key, &item)
+		if err != nil {
+			return err
+		}
+		if found && selector.Matches(labels.Set(item.Labels)) {
+			logrus.Debugf("JivaVolume: {%v} is missing in cache, read from API server", item.Name)
+			items = append(items, item)
+		}
+	}
+	list.Items = items
 	return nil
 }
 
+// getLatest reads the given JivaVolume from the API server, it returns
+// false if the volume is deleted
+func (cl *Client) getLatest(ctx context.Context, key types.NamespacedName, obj *jv.JivaVolume) (bool, error) {
+	if err := cl.apiReader().Get(ctx, key, obj); errors.IsNotFound(err) {
+		cl.forget(&jv.JivaVolume{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}})
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // GetJivaVolume get the instance of JivaVolume CR.
-func (cl *Client) GetJivaVolume(name string) (*jv.JivaVolume, error) {
-	instance, err := cl.ListJivaVolume(name)
-	if err != nil {
+func (cl *Client) GetJivaVolume(ctx context.Context, name string) (*jv.JivaVolume, error) {
+	instance, err := cl.ListJivaVolume(ctx, name)
+	if err != nil && ctx.Err() != nil {
+		return nil, status.FromContextError(ctx.Err()).Err()
+	} else if err != nil {
 		logrus.Errorf("Failed to get JivaVolume CR: %v, err: %v", name, err)
 		return nil, status.Errorf(codes.Internal, "Failed to get JivaVolume CR: {%v}, err: {%v}", name, err)
 	}
@@ -102,16 +321,6 @@ func (cl *Client) GetJivaVolume(name string) (*jv.JivaVolume, error) {
 	return &instance.Items[0], nil
 }
 
-// UpdateJivaVolume update the JivaVolume CR
-func (cl *Client) UpdateJivaVolume(cr *jv.JivaVolume) error {
-	err := cl.client.Update(context.TODO(), cr)
-	if err != nil {
-		logrus.Errorf("Failed to update JivaVolume CR: {%v}, err: {%v}", cr.Name, err)
-		return err
-	}
-	return nil
-}
-
 func getDefaultLabels(pv string) map[string]string {
 	return map[string]string{
 		"openebs.io/persistent-volume": pv,
@@ -127,9 +336,19 @@ func getdefaultAnnotations(policy string) map[string]string {
 	return annotations
 }
 
+// GetNamespace returns the namespace in which the JivaVolume CR
+// is created for the given volume parameters
+func GetNamespace(params map[string]string) string {
+	ns, ok := params["namespace"]
+	if !ok {
+		ns = defaultNS
+	}
+	return ns
+}
+
 // CreateJivaVolume check whether JivaVolume CR already exists and creates one
 // if it doesn't exist.
-func (cl *Client) CreateJivaVolume(req *csi.CreateVolumeRequest) error {
+func (cl *Client) CreateJivaVolume(ctx context.Context, req *csi.CreateVolumeRequest) error {
 	var sizeBytes int64
 	name := utils.StripName(req.GetName())
 	policyName := req.GetParameters()["policy"]
@@ -160,13 +379,14 @@ func (cl *Client) CreateJivaVolume(req *csi.CreateVolumeRequest) error {
 
 	obj := jiva.Instance()
 	objExists := &jv.JivaVolume{}
-	err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, objExists)
+	err := cl.apiReader().Get(ctx, types.NamespacedName{Name: name, Namespace: ns}, objExists)
 	if err != nil && errors.IsNotFound(err) {
 		logrus.Infof("Creating a new JivaVolume CR {name: %v, namespace: %v}", name, ns)
-		err = cl.client.Create(context.TODO(), obj)
+		err = cl.client.Create(ctx, obj)
 		if err != nil {
 			return status.Errorf(codes.Internal, "Failed to create JivaVolume CR, err: {%v}", err)
 		}
+		cl.setWritten(obj)
 		return nil
 	} else if err != nil {
 		return status.Errorf(codes.Internal, "Failed to get the JivaVolume details, err: {%v}", err)
@@ -180,14 +400,18 @@ func (cl *Client) CreateJivaVolume(req *csi.CreateVolumeRequest) error {
 }
 
 // ListJivaVolume returns the list of JivaVolume resources
-func (cl *Client) ListJivaVolume(volumeID string) (*jv.JivaVolumeList, error) {
+func (cl *Client) ListJivaVolume(ctx context.Context, volumeID string) (*jv.JivaVolumeList, error) {
 	volumeID = utils.StripName(volumeID)
 	obj := &jv.JivaVolumeList{}
 	opts := []client.ListOption{
 		client.MatchingLabels(getDefaultLabels(volumeID)),
 	}
 
-	if err := cl.client.List(context.TODO(), obj, opts...); err != nil {
+	if err := cl.volumeReader().List(ctx, obj, opts...); err != nil {
+		return nil, err
+	}
+
+	if err := cl.latest(ctx, obj, getDefaultLabels(volumeID)); err != nil {
 		return nil, err
 	}
 
@@ -195,22 +419,32 @@ func (cl *Client) ListJivaVolume(volumeID string) (*jv.JivaVolumeList, error) {
 }
 
 // ListJivaVolumeWithOpts returns the list of JivaVolume resources
-func (cl *Client) ListJivaVolumeWithOpts(opts map[string]string) (*jv.JivaVolumeList, error) {
+func (cl *Client) ListJivaVolumeWithOpts(ctx context.Context, opts map[string]string) (*jv.JivaVolumeList, error) {
 	obj := &jv.JivaVolumeList{}
 	options := []client.ListOption{
 		client.MatchingLabels(opts),
 	}
 
-	if err := cl.client.List(context.TODO(), obj, options...); err != nil {
+	if err := cl.volumeReader().List(ctx, obj, options...); err != nil {
+		return nil, err
+	}
+
+	if err := cl.latest(ctx, obj, opts); err != nil {
 		return nil, err
 	}
 
 	return obj, nil
 }
 
+// CheckJivaVolumeAccess lists a JivaVolume from the API server, bypassing
+// the cache, to verify that the JivaVolumes can be accessed
+func (cl *Client) CheckJivaVolumeAccess(ctx context.Context) error {
+	return cl.apiReader().List(ctx, &jv.JivaVolumeList{}, client.Limit(1))
+}
+
 // DeleteJivaVolume delete the JivaVolume CR
-func (cl *Client) DeleteJivaVolume(volumeID string) error {
-	obj, err := cl.ListJivaVolume(volumeID)
+func (cl *Client) DeleteJivaVolume(ctx context.Context, volumeID string) error {
+	obj, err := cl.ListJivaVolume(ctx, volumeID)
 	if err != nil {
 		return err
 	}
@@ -222,8 +456,59 @@ func (cl *Client) DeleteJivaVolume(volumeID string) error {
 
 	logrus.Debugf("DeleteVolume: object: {%+v}", obj)
 	instance := obj.Items[0].DeepCopy()
-	if err := cl.client.Delete(context.TODO(), instance); err != nil {
+	if err := cl.client.Delete(ctx, instance); err != nil {
 		return err
 	}
+	cl.forget(instance)
 	return nil
 }
+
+// GetJivaVolumePolicySpec returns the policy spec which will be used by
+// jiva-operator to provision the volume with the given policy name, defaults
+// are set for the fields which are not provided in the policy
+func (cl *Client) GetJivaVolumePolicySpec(ctx context.Context, name, ns string) (*jv.JivaVolumePolicySpec, error) {
+	spec := jv.JivaVolumePolicySpec{}
+	if name != "" {
+		policy := &jv.JivaVolumePolicy{}
+		if err := cl.apiReader().Get(ctx, types.NamespacedName{Name: name, Namespace: ns}, policy); err != nil {
+			return nil, err
+		}
+		spec = policy.Spec
+	}
+
+	if spec.ReplicaSC == "" {
+		spec.ReplicaSC = defaultReplicaSC
+	}
+
+	if spec.Target.ReplicationFactor == 0 {
+		spec.Target.ReplicationFactor = defaultReplicationFactor
+	}
+	return &spec, nil
+}
+
+// ListNodes returns the list of nodes matching the given labels
+func (cl *Client) ListNodes(ctx context.Context, labels map[string]string) (*corev1.NodeList, error) {
+	obj := &corev1.NodeList{}
+	if err := cl.apiReader().List(ctx, obj, client.MatchingLabels(labels)); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// GetNode returns the node with the given name
+func (cl *Client) GetNode(ctx context.Context, name string) (*corev1.Node, error) {
+	obj := &corev1.Node{}
+	if err := cl.apiReader().Get(ctx, types.NamespacedName{Name: name}, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ListPersistentVolumes returns the list of persistent volumes
+func (cl *Client) ListPersistentVolumes(ctx context.Context) (*corev1.PersistentVolumeList, error) {
+	obj := &corev1.PersistentVolumeList{}
+	if err := cl.apiReader().List(ctx, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
@@ -0,0 +1,33 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("isObservedVersion",
+	func(cached, written string, observed bool) {
+		Expect(isObservedVersion(cached, written)).Should(Equal(observed))
+	},
+	Entry("written version", "10", "10", true),
+	Entry("newer version", "11", "10", true),
+	Entry("older version", "9", "10", false),
+	Entry("opaque written version", "abc", "abc", true),
+	Entry("opaque newer version", "abd", "abc", false),
+)
@@ -0,0 +1,163 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// Reasons of the events emitted on the PV and PVC of the volumes
+const (
+	ReasonVolumeNotReady = "VolumeNotReady"
+	ReasonAttachFailed   = "AttachFailed"
+	ReasonRemountFailed  = "RemountFailed"
+	ReasonRemounted      = "Remounted"
+	ReasonResizeFailed   = "ResizeFailed"
+	ReasonResized        = "Resized"
+)
+
+const (
+	eventComponent = "jiva-csi"
+
+	// eventBurst and eventQPS limit the events of each volume, so that
+	// a flapping volume emits a burst of events followed by at most one
+	// event per minute
+	eventBurst = 5
+	eventQPS   = 1.0 / 60
+
+	// eventLimiterCacheSize is the number of volumes
+	// whose event rate limiters are kept in memory
+	eventLimiterCacheSize = 4096
+	eventLimiterTTL       = time.Hour
+
+	// eventTimeout is the timeout to read the PV of the event, the
+	// event is recorded in the background so it is not bound to the
+	// context of the caller
+	eventTimeout = 10 * time.Second
+)
+
+// eventRecorder emits the events of the volumes on their PV and the bound
+// PVC, the events of each volume are rate limited before the PV is read
+// and again by the spam filter of the event broadcaster
+type eventRecorder struct {
+	recorder record.EventRecorder
+
+	mu       sync.Mutex
+	limiters *utilcache.LRUExpireCache
+}
+
+func newEventRecorder(config *rest.Config) (*eventRecorder, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize: eventBurst,
+		QPS:       eventQPS,
+	})
+	broadcaster.StartLogging(logrus.Debugf)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: clientset.CoreV1().Events(""),
+	})
+
+	return &eventRecorder{
+		recorder: broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent}),
+		limiters: utilcache.NewLRUExpireCache(eventLimiterCacheSize),
+	}, nil
+}
+
+// allow returns false if the events of the given volume are rate limited
+func (r *eventRecorder) allow(pv string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	limiter, ok := r.limiters.Get(pv)
+	if !ok {
+		limiter = flowcontrol.NewTokenBucketRateLimiter(eventQPS, eventBurst)
+	}
+	r.limiters.Add(pv, limiter, eventLimiterTTL)
+	return limiter.(flowcontrol.RateLimiter).TryAccept()
+}
+
+// getPVName returns the name of the PV of the given JivaVolume
+func getPVName(instance *jv.JivaVolume) string {
+	if pv := instance.Labels["openebs.io/persistent-volume"]; pv != "" {
+		return pv
+	}
+	return instance.Name
+}
+
+// WarningEventf emits a warning event on the PV and PVC of the volume
+func (cl *Client) WarningEventf(instance *jv.JivaVolume, reason, messageFmt string, args ...interface{}) {
+	cl.eventf(instance, corev1.EventTypeWarning, reason, messageFmt, args...)
+}
+
+// NormalEventf emits a normal event on the PV and PVC of the volume
+func (cl *Client) NormalEventf(instance *jv.JivaVolume, reason, messageFmt string, args ...interface{}) {
+	cl.eventf(instance, corev1.EventTypeNormal, reason, messageFmt, args...)
+}
+
+// eventf emits the event in the background so that the callers
+// are not blocked on reading the PV from the API server
+func (cl *Client) eventf(instance *jv.JivaVolume, eventtype, reason, messageFmt string, args ...interface{}) {
+	if cl.events == nil || instance == nil {
+		return
+	}
+
+	pvName := getPVName(instance)
+	if !cl.events.allow(pvName) {
+		logrus.Debugf("Event: {%s} of volume: {%s} is rate limited", reason, pvName)
+		return
+	}
+
+	message := fmt.Sprintf(messageFmt, args...)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+		defer cancel()
+
+		pv := &corev1.PersistentVolume{}
+		if err := cl.apiReader().Get(ctx, types.NamespacedName{Name: pvName}, pv); err != nil {
+			logrus.Warningf("Failed to get PV: {%s} to record event: {%s}, err: {%v}", pvName, reason, err)
+			return
+		}
+
+		cl.events.recorder.Event(pv, eventtype, reason, message)
+		if pv.Spec.ClaimRef == nil || pv.Status.Phase != corev1.VolumeBound {
+			return
+		}
+		claim := pv.Spec.ClaimRef.DeepCopy()
+		if claim.Kind == "" {
+			claim.Kind, claim.APIVersion = "PersistentVolumeClaim", "v1"
+		}
+		cl.events.recorder.Event(claim, eventtype, reason, message)
+	}()
+}
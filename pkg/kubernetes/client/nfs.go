@@ -0,0 +1,360 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// NFSVolumeLabelKey is the label set on the resources of the
+	// NFS gateway with the value of the RWX volume served by it
+	NFSVolumeLabelKey = "openebs.io/nfs-volume"
+
+	nfsPort       = 2049
+	nfsExportPath = "/nfsshare"
+)
+
+// NFSGateway contains the details required to create a NFS server in
+// front of a jiva volume to serve the volume to multiple nodes
+type NFSGateway struct {
+	// Name of the RWX volume served by the gateway
+	VolumeID string
+	// Backend is the name of the jiva volume and the
+	// PV, PVC, deployment and service of the gateway
+	Backend   string
+	Namespace string
+	Capacity  resource.Quantity
+	// DriverName is the name of this CSI driver which
+	// serves the backend volume
+	DriverName string
+	FSType     string
+	Image      string
+}
+
+func (gw *NFSGateway) labels() map[string]string {
+	return map[string]string{
+		NFSVolumeLabelKey:      gw.VolumeID,
+		"openebs.io/component": "jiva-nfs-gateway",
+	}
+}
+
+func (gw *NFSGateway) persistentVolume() *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   gw.Backend,
+			Labels: gw.labels(),
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: gw.Capacity,
+			},
+			AccessModes: []corev1.PersistentVolumeAccessMode{
+				corev1.ReadWriteOnce,
+			},
+			// backend volume is deleted along with the RWX volume
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+			ClaimRef: &corev1.ObjectReference{
+				Kind:      "PersistentVolumeClaim",
+				Namespace: gw.Namespace,
+				Name:      gw.Backend,
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       gw.DriverName,
+					VolumeHandle: gw.Backend,
+					FSType:       gw.FSType,
+				},
+			},
+		},
+	}
+}
+
+func (gw *NFSGateway) persistentVolumeClaim() *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      gw.Backend,
+			Namespace: gw.Namespace,
+			Labels:    gw.labels(),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{
+				corev1.ReadWriteOnce,
+			},
+			StorageClassName: pointer.StringPtr(""),
+			VolumeName:       gw.Backend,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: gw.Capacity,
+				},
+			},
+		},
+	}
+}
+
+func (gw *NFSGateway) deployment() *appsv1.Deployment {
+	labels := gw.labels()
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      gw.Backend,
+			Namespace: gw.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: pointer.Int32Ptr(1),
+			Strategy: appsv1.DeploymentStrategy{
+				// backend volume can be attached to only one node
+				Type: appsv1.RecreateDeploymentStrategyType,
+			},
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "nfs-server",
+							Image: gw.Image,
+							Env: []corev1.EnvVar{
+								{
+									Name:  "SHARED_DIRECTORY",
+									Value: nfsExportPath,
+								},
+							},
+							Ports: []corev1.ContainerPort{
+								{
+									Name:          "nfs",
+									ContainerPort: nfsPort,
+								},
+							},
+							SecurityContext: &corev1.SecurityContext{
+								Privileged: pointer.BoolPtr(true),
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "backend",
+									MountPath: nfsExportPath,
+								},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "backend",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: gw.Backend,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (gw *NFSGateway) service() *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      gw.Backend,
+			Namespace: gw.Namespace,
+			Labels:    gw.labels(),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: gw.labels(),
+			Ports: []corev1.ServicePort{
+				{
+					Name: "nfs",
+					Port: nfsPort,
+				},
+			},
+		},
+	}
+}
+
+// createIfNotExists creates the given object, it is a
+// no-op if the object already exists
+func (cl *Client) createIfNotExists(ctx context.Context, obj runtime.Object) error {
+	err := cl.client.Create(ctx, obj)
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// CreateNFSGateway creates the PV and PVC of the backend jiva volume, and
+// the NFS server deployment and service which export the backend volume.
+// It returns the cluster IP of the NFS service
+func (cl *Client) CreateNFSGateway(ctx context.Context, gw *NFSGateway) (string, error) {
+	for _, obj := range []runtime.Object{
+		gw.persistentVolume(),
+		gw.persistentVolumeClaim(),
+		gw.deployment(),
+		gw.service(),
+	} {
+		if err := cl.createIfNotExists(ctx, obj); err != nil {
+			return "", fmt.Errorf("failed to create %T of NFS gateway: {%s}, err: {%v}", obj, gw.Backend, err)
+		}
+	}
+
+	svc := &corev1.Service{}
+	if err := cl.apiReader().Get(ctx, types.NamespacedName{
+		Name:      gw.Backend,
+		Namespace: gw.Namespace,
+	}, svc); err != nil {
+		return "", err
+	}
+
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		return "", fmt.Errorf("cluster IP is not assigned to NFS service: {%s}", gw.Backend)
+	}
+	return svc.Spec.ClusterIP, nil
+}
+
+// IsNFSGatewayAvailable checks if the NFS server deployment
+// of the given gateway is available to serve the export
+func (cl *Client) IsNFSGatewayAvailable(ctx context.Context, gw *NFSGateway) (bool, error) {
+	deploy := &appsv1.Deployment{}
+	if err := cl.apiReader().Get(ctx, types.NamespacedName{
+		Name:      gw.Backend,
+		Namespace: gw.Namespace,
+	}, deploy); err != nil {
+		return false, err
+	}
+
+	return deploy.Status.ObservedGeneration >= deploy.Generation &&
+		deploy.Status.AvailableReplicas > 0, nil
+}
+
+// DeleteNFSGateway deletes the resources of the NFS gateway of the
+// given RWX volume, it is a no-op if the volume is not served by
+// a NFS gateway. It returns true if the backend PV is not yet removed,
+// i.e. the backend volume may still be attached to the NFS server node
+func (cl *Client) DeleteNFSGateway(ctx context.Context, volumeID string) (bool, error) {
+	opts := client.MatchingLabels(map[string]string{
+		NFSVolumeLabelKey: volumeID,
+	})
+
+	lists := []runtime.Object{
+		&appsv1.DeploymentList{},
+		&corev1.ServiceList{},
+		&corev1.PersistentVolumeClaimList{},
+		&corev1.PersistentVolumeList{},
+	}
+
+	// resources are deleted in order so that the backend volume
+	// is released by the NFS server before deleting its PVC and PV
+	for _, list := range lists {
+		if err := cl.apiReader().List(ctx, list, opts); err != nil {
+			return false, err
+		}
+
+		var objs []runtime.Object
+		switch l := list.(type) {
+		case *appsv1.DeploymentList:
+			for i := range l.Items {
+				objs = append(objs, &l.Items[i])
+			}
+		case *corev1.ServiceList:
+			for i := range l.Items {
+				objs = append(objs, &l.Items[i])
+			}
+		case *corev1.PersistentVolumeClaimList:
+			for i := range l.Items {
+				objs = append(objs, &l.Items[i])
+			}
+		case *corev1.PersistentVolumeList:
+			for i := range l.Items {
+				objs = append(objs, &l.Items[i])
+			}
+		}
+
+		for _, obj := range objs {
+			logrus.Infof("DeleteVolume: deleting %T of NFS gateway of volume: {%s}", obj, volumeID)
+			if err := cl.client.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+				return false, err
+			}
+		}
+	}
+
+	pvs := &corev1.PersistentVolumeList{}
+	if err := cl.apiReader().List(ctx, pvs, opts); err != nil {
+		return false, err
+	}
+	return len(pvs.Items) != 0, nil
+}
+
+// ResizeNFSGateway updates the capacity of the PV of the given backend volume
+// and marks its PVC as pending filesystem resize, so that the filesystem of
+// the backend volume is expanded by kubelet on the node of the NFS server
+func (cl *Client) ResizeNFSGateway(ctx context.Context, backend, namespace string, capacity resource.Quantity) error {
+	pv := &corev1.PersistentVolume{}
+	if err := cl.apiReader().Get(ctx, types.NamespacedName{Name: backend}, pv); err != nil {
+		return err
+	}
+
+	if cur := pv.Spec.Capacity[corev1.ResourceStorage]; cur.Cmp(capacity) < 0 {
+		pv.Spec.Capacity[corev1.ResourceStorage] = capacity
+		if err := cl.client.Update(ctx, pv); err != nil {
+			return fmt.Errorf("failed to update capacity of PV: {%s}, err: {%v}", backend, err)
+		}
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := cl.apiReader().Get(ctx, types.NamespacedName{
+		Name:      backend,
+		Namespace: namespace,
+	}, pvc); err != nil {
+		return err
+	}
+
+	if cur := pvc.Status.Capacity[corev1.ResourceStorage]; cur.Cmp(capacity) >= 0 {
+		return nil
+	}
+	for _, cond := range pvc.Status.Conditions {
+		if cond.Type == corev1.PersistentVolumeClaimFileSystemResizePending {
+			return nil
+		}
+	}
+
+	pvc.Status.Conditions = append(pvc.Status.Conditions, corev1.PersistentVolumeClaimCondition{
+		Type:               corev1.PersistentVolumeClaimFileSystemResizePending,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: metav1.Now(),
+		Message:            "Waiting for file system resize of backend volume on the node of NFS server",
+	})
+	if err := cl.client.Status().Update(ctx, pvc); err != nil {
+		return fmt.Errorf("failed to update status of PVC: {%s}, err: {%v}", backend, err)
+	}
+	return nil
+}
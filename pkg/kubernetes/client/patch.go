@@ -0,0 +1,212 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MountInfoPatch is the mount info of the JivaVolume to patch,
+// the fields which are nil are left unchanged
+type MountInfoPatch struct {
+	StagingPath *string
+	TargetPath  *string
+	FSType      *string
+	DevicePath  *string
+}
+
+// JivaVolumePatch is the set of fields of the JivaVolume owned by
+// jiva-csi to patch, the fields which are not set are left unchanged
+// so that the fields owned by jiva-operator are never overwritten.
+// Labels and annotations which are set to nil are removed
+type JivaVolumePatch struct {
+	Labels      map[string]*string
+	Annotations map[string]*string
+	MountInfo   *MountInfoPatch
+	Capacity    string
+}
+
+// data returns the merge patch, the resource version if given is set
+// as the precondition of the patch so that it fails with a conflict if
+// the JivaVolume is modified after the patch is computed
+func (p JivaVolumePatch) data(resourceVersion string) ([]byte, error) {
+	metadata := map[string]interface{}{}
+	if resourceVersion != "" {
+		metadata["resourceVersion"] = resourceVersion
+	}
+	if len(p.Labels) != 0 {
+		metadata["labels"] = p.Labels
+	}
+	if len(p.Annotations) != 0 {
+		metadata["annotations"] = p.Annotations
+	}
+
+	spec := map[string]interface{}{}
+	if p.MountInfo != nil {
+		mountInfo := map[string]string{}
+		for key, value := range map[string]*string{
+			"stagingPath": p.MountInfo.StagingPath,
+			"targetPath":  p.MountInfo.TargetPath,
+			"fsType":      p.MountInfo.FSType,
+			"devicePath":  p.MountInfo.DevicePath,
+		} {
+			if value != nil {
+				mountInfo[key] = *value
+			}
+		}
+		spec["mountInfo"] = mountInfo
+	}
+	if p.Capacity != "" {
+		spec["capacity"] = p.Capacity
+	}
+
+	patch := map[string]interface{}{}
+	if len(metadata) != 0 {
+		patch["metadata"] = metadata
+	}
+	if len(spec) != 0 {
+		patch["spec"] = spec
+	}
+	return json.Marshal(patch)
+}
+
+// JSONPatchOperation is an operation of the JSON patch (RFC 6902)
+type JSONPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// patchJivaVolume applies the patch returned by data to the given JivaVolume.
+// The patches which depend on the instance have its resource version as
+// precondition, so on conflict with the concurrent writers of the JivaVolume,
+// i.e. the operator or other RPCs, the instance is read again from the API
+// server and the patch is computed again from it
+func (cl *Client) patchJivaVolume(ctx context.Context, instance *jv.JivaVolume,
+	patchType types.PatchType, data func(*jv.JivaVolume) ([]byte, error)) error {
+	// failed test operation of a JSON patch is rejected as invalid
+	isStale := func(err error) bool {
+		return errors.IsConflict(err) || (patchType == types.JSONPatchType && errors.IsInvalid(err))
+	}
+
+	stale := false
+	err := retry.OnError(retry.DefaultRetry, isStale, func() error {
+		if stale {
+			if err := cl.apiReader().Get(ctx, types.NamespacedName{
+				Name:      instance.Name,
+				Namespace: instance.Namespace,
+			}, instance); err != nil {
+				return err
+			}
+		}
+
+		patch, err := data(instance)
+		if err != nil {
+			return err
+		}
+
+		logrus.Debugf("Patching JivaVolume CR: {%v}, patch: {%s}", instance.Name, patch)
+		err = cl.client.Patch(ctx, instance, client.ConstantPatch(patchType, patch))
+		stale = isStale(err)
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Failed to patch JivaVolume CR: {%v}, err: {%v}", instance.Name, err)
+		return err
+	}
+	cl.setWritten(instance)
+	return nil
+}
+
+// PatchJivaVolume patches the given fields of the JivaVolume CR, the
+// instance is updated with the JivaVolume returned by the API server.
+// The patch doesn't depend on the instance, so it has no precondition
+func (cl *Client) PatchJivaVolume(ctx context.Context, instance *jv.JivaVolume, patch JivaVolumePatch) error {
+	return cl.patchJivaVolume(ctx, instance, types.MergePatchType, func(*jv.JivaVolume) ([]byte, error) {
+		return patch.data("")
+	})
+}
+
+// PatchJivaVolumeFunc patches the fields of the JivaVolume CR returned by
+// fn, fn is called again with the latest instance on conflict so that the
+// patches which depend on the JivaVolume are computed from its latest state
+func (cl *Client) PatchJivaVolumeFunc(ctx context.Context, instance *jv.JivaVolume,
+	fn func(*jv.JivaVolume) (JivaVolumePatch, error)) error {
+	return cl.patchJivaVolume(ctx, instance, types.MergePatchType, func(obj *jv.JivaVolume) ([]byte, error) {
+		patch, err := fn(obj)
+		if err != nil {
+			return nil, err
+		}
+		return patch.data(obj.ResourceVersion)
+	})
+}
+
+// JSONPatchJivaVolume applies the given JSON patch operations to the
+// JivaVolume CR, the resource version is tested as the first operation
+// so that the patch is rejected if the JivaVolume is modified
+func (cl *Client) JSONPatchJivaVolume(ctx context.Context, instance *jv.JivaVolume, ops []JSONPatchOperation) error {
+	return cl.patchJivaVolume(ctx, instance, types.JSONPatchType, func(obj *jv.JivaVolume) ([]byte, error) {
+		return json.Marshal(append([]JSONPatchOperation{{
+			Op:    "test",
+			Path:  "/metadata/resourceVersion",
+			Value: obj.ResourceVersion,
+		}}, ops...))
+	})
+}
+
+// PatchMountInfo patches the mount info of the JivaVolume CR
+func (cl *Client) PatchMountInfo(ctx context.Context, instance *jv.JivaVolume, info MountInfoPatch) error {
+	return cl.PatchJivaVolume(ctx, instance, JivaVolumePatch{MountInfo: &info})
+}
+
+// PatchAnnotations patches the annotations of the JivaVolume CR
+func (cl *Client) PatchAnnotations(ctx context.Context, instance *jv.JivaVolume, annotations map[string]*string) error {
+	return cl.PatchJivaVolume(ctx, instance, JivaVolumePatch{Annotations: annotations})
+}
+
+// PatchCapacity patches the capacity of the JivaVolume CR
+func (cl *Client) PatchCapacity(ctx context.Context, instance *jv.JivaVolume, capacity string) error {
+	return cl.JSONPatchJivaVolume(ctx, instance, []JSONPatchOperation{{
+		Op:    "replace",
+		Path:  "/spec/capacity",
+		Value: capacity,
+	}})
+}
+
+// PatchNodeAnnotations patches the annotations of the given node,
+// annotations which are set to nil are removed. The patch doesn't
+// conflict with other writers, since only the given keys are patched
+func (cl *Client) PatchNodeAnnotations(ctx context.Context, node *corev1.Node, annotations map[string]*string) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return cl.client.Patch(ctx, node, client.ConstantPatch(types.MergePatchType, data))
+}
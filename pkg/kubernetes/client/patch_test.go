@@ -0,0 +1,40 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/pointer"
+)
+
+var _ = DescribeTable("JivaVolumePatch.data",
+	func(patch JivaVolumePatch, resourceVersion, expected string) {
+		data, err := patch.data(resourceVersion)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(data)).Should(MatchJSON(expected))
+	},
+	Entry("without precondition",
+		JivaVolumePatch{Labels: map[string]*string{"nodeID": pointer.StringPtr("node-1")}}, "",
+		`{"metadata": {"labels": {"nodeID": "node-1"}}}`),
+	Entry("with precondition",
+		JivaVolumePatch{Annotations: map[string]*string{"key": nil}}, "10",
+		`{"metadata": {"resourceVersion": "10", "annotations": {"key": null}}}`),
+	Entry("spec only",
+		JivaVolumePatch{Capacity: "5Gi"}, "",
+		`{"spec": {"capacity": "5Gi"}}`),
+)
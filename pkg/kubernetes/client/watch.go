@@ -0,0 +1,184 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/scheme"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+var (
+	// backoff between the reads of the JivaVolume while waiting,
+	// events from the watch wake up the waiters before the backoff
+	waitBackoffDuration = time.Second
+	waitBackoffFactor   = 2.0
+	waitBackoffCap      = 30 * time.Second
+)
+
+// volumeWatcher runs a shared informer on JivaVolumes and notifies the
+// waiters of a volume whenever the volume is updated
+type volumeWatcher struct {
+	once  sync.Once
+	err   error
+	cache cache.Cache
+
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+func newVolumeWatcher() *volumeWatcher {
+	return &volumeWatcher{
+		waiters: map[string]map[chan struct{}]struct{}{},
+	}
+}
+
+// start adds the event handler to the informer of JivaVolumes once. The
+// informer of the manager cache is used if it is started, else a new
+// cache is started which runs till the driver process exits
+func (w *volumeWatcher) start(cl *Client) error {
+	w.once.Do(func() {
+		c := cl.cache
+		if c == nil {
+			var err error
+			if c, err = cache.New(cl.cfg, cache.Options{Scheme: scheme.Scheme}); err != nil {
+				w.err = err
+				return
+			}
+			go func() {
+				if err := c.Start(make(chan struct{})); err != nil {
+					logrus.Errorf("JivaVolume watch stopped, err: {%v}", err)
+				}
+			}()
+		}
+
+		informer, err := c.GetInformer(&jv.JivaVolume{})
+		if err != nil {
+			w.err = err
+			return
+		}
+
+		informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+			AddFunc:    w.notify,
+			UpdateFunc: func(_, obj interface{}) { w.notify(obj) },
+			DeleteFunc: w.notify,
+		})
+		w.cache = c
+		logrus.Info("Started watch on JivaVolumes")
+	})
+	return w.err
+}
+
+// notify wakes up the waiters of the given JivaVolume
+func (w *volumeWatcher) notify(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	instance, ok := obj.(*jv.JivaVolume)
+	if !ok {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.waiters[instance.Name] {
+		// waiter re-reads the volume, so a pending
+		// notification is enough
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *volumeWatcher) subscribe(name string) chan struct{} {
+	ch := make(chan struct{}, 1)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.waiters[name] == nil {
+		w.waiters[name] = map[chan struct{}]struct{}{}
+	}
+	w.waiters[name][ch] = struct{}{}
+	return ch
+}
+
+func (w *volumeWatcher) unsubscribe(name string, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.waiters[name], ch)
+	if len(w.waiters[name]) == 0 {
+		delete(w.waiters, name)
+	}
+}
+
+// VolumeCondition is the condition of the JivaVolume to wait for, it
+// returns true once the condition is met and an error to stop waiting
+type VolumeCondition func(instance *jv.JivaVolume) (bool, error)
+
+// WaitForJivaVolume waits till the given condition is met for the JivaVolume.
+// The volume is checked whenever it is updated as notified by the watch, and
+// with an exponential backoff in case the watch is not available or an event
+// is missed. It returns the error of the context if it is done before
+func (cl *Client) WaitForJivaVolume(ctx context.Context, name string, cond VolumeCondition) (*jv.JivaVolume, error) {
+	var events chan struct{}
+	if err := cl.watcher.start(cl); err != nil {
+		logrus.Warningf("Failed to watch JivaVolumes, falling back to polling, err: {%v}", err)
+	} else {
+		events = cl.watcher.subscribe(name)
+		defer cl.watcher.unsubscribe(name, events)
+	}
+
+	backoff := wait.Backoff{
+		Duration: waitBackoffDuration,
+		Factor:   waitBackoffFactor,
+		Cap:      waitBackoffCap,
+		// steps are not limited, context decides the timeout
+		Steps: int(^uint(0) >> 1),
+	}
+
+	for {
+		instance, err := cl.GetJivaVolume(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		done, err := cond(instance)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return instance, nil
+		}
+
+		timer := time.NewTimer(backoff.Step())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return instance, ctx.Err()
+		case <-events:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
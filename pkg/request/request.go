@@ -18,37 +18,104 @@ package request
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 )
 
-var (
+// Operation is the operation in progress on a volume
+type Operation struct {
+	VolumeID string
+	// Type is the operation, i.e. the RPC or Remount
+	Type string
+	// Owner is the component which started the operation
+	Owner   string
+	Started time.Time
+}
 
-	// TransitionVolList contains the list of volumes under transition
-	// This list is protected by TransitionVolListLock
-	TransitionVolList map[string]string
+func (op Operation) String() string {
+	return fmt.Sprintf("%s by %s since %s (%v)", op.Type, op.Owner,
+		op.Started.Format(time.RFC3339), time.Since(op.Started).Round(time.Second))
+}
 
-	// TransitionVolListLock is required to protect the above Volumes list
-	TransitionVolListLock sync.RWMutex
-)
+// BusyError is returned when the volume is locked by another operation
+type BusyError struct {
+	Operation Operation
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("Volume Busy, %s is already in progress", e.Operation)
+}
 
-func init() {
-	TransitionVolList = make(map[string]string)
+// VolumeLocks serializes the operations on each volume, the volumes
+// are locked independently so that the operations on a volume never
+// wait for the operations on other volumes
+type VolumeLocks struct {
+	mu  sync.Mutex
+	ops map[string]*Operation
 }
 
-func RemoveVolumeFromTransitionList(volumeID string) {
-	TransitionVolListLock.Lock()
-	defer TransitionVolListLock.Unlock()
-	delete(TransitionVolList, volumeID)
+// NewVolumeLocks returns a new instance of VolumeLocks
+func NewVolumeLocks() *VolumeLocks {
+	return &VolumeLocks{
+		ops: map[string]*Operation{},
+	}
 }
 
-func AddVolumeToTransitionList(volumeID string, req string) error {
-	TransitionVolListLock.Lock()
-	defer TransitionVolListLock.Unlock()
+// TryLock locks the volume for the given operation without waiting, it
+// returns BusyError with the operation in progress if the volume is already
+// locked. The returned func unlocks the volume, it is a no-op if called
+// more than once
+func (l *VolumeLocks) TryLock(volumeID, opType, owner string) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if op, ok := l.ops[volumeID]; ok {
+		return nil, &BusyError{Operation: *op}
+	}
+
+	op := &Operation{
+		VolumeID: volumeID,
+		Type:     opType,
+		Owner:    owner,
+		Started:  time.Now(),
+	}
+	l.ops[volumeID] = op
+	return func() { l.unlock(op) }, nil
+}
+
+// unlock releases the volume if it is still locked by the given operation
+func (l *VolumeLocks) unlock(op *Operation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ops[op.VolumeID] == op {
+		delete(l.ops, op.VolumeID)
+	}
+}
+
+// List returns the operations in progress, oldest first
+func (l *VolumeLocks) List() []Operation {
+	l.mu.Lock()
+	ops := make([]Operation, 0, len(l.ops))
+	for _, op := range l.ops {
+		ops = append(ops, *op)
+	}
+	l.mu.Unlock()
+
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].Started.Before(ops[j].Started)
+	})
+	return ops
+}
 
-	if _, ok := TransitionVolList[volumeID]; ok {
-		return fmt.Errorf("Volume Busy, %v is already in progress",
-			TransitionVolList[volumeID])
+// Stuck returns the operations which are in progress
+// for longer than the given duration, oldest first
+func (l *VolumeLocks) Stuck(d time.Duration) []Operation {
+	var stuck []Operation
+	for _, op := range l.List() {
+		if time.Since(op.Started) > d {
+			stuck = append(stuck, op)
+		}
 	}
-	TransitionVolList[volumeID] = req
-	return nil
+	return stuck
 }
@@ -0,0 +1,124 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package request
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VolumeLocks", func() {
+	var locks *VolumeLocks
+
+	BeforeEach(func() {
+		locks = NewVolumeLocks()
+	})
+
+	// locked returns the operation in progress on the volume
+	locked := func(volumeID string) (Operation, bool) {
+		for _, op := range locks.List() {
+			if op.VolumeID == volumeID {
+				return op, true
+			}
+		}
+		return Operation{}, false
+	}
+
+	DescribeTable("TryLock",
+		func(locked []string, volumeID string, busy bool) {
+			for _, id := range locked {
+				_, err := locks.TryLock(id, "NodeStageVolume", "node")
+				Expect(err).ShouldNot(HaveOccurred())
+			}
+
+			unlock, err := locks.TryLock(volumeID, "NodePublishVolume", "node")
+			if !busy {
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(unlock).ShouldNot(BeNil())
+				return
+			}
+
+			Expect(err).Should(HaveOccurred())
+			busyErr, ok := err.(*BusyError)
+			Expect(ok).Should(BeTrue())
+			Expect(busyErr.Operation.VolumeID).Should(Equal(volumeID))
+			Expect(busyErr.Operation.Type).Should(Equal("NodeStageVolume"))
+			Expect(err.Error()).Should(ContainSubstring("NodeStageVolume by node"))
+		},
+		Entry("free volume is locked", nil, "pvc-1", false),
+		Entry("volume is locked independently of others", []string{"pvc-2"}, "pvc-1", false),
+		Entry("locked volume is busy", []string{"pvc-1"}, "pvc-1", true),
+	)
+
+	DescribeTable("unlock",
+		func(unlocks int, relock bool) {
+			unlock, err := locks.TryLock("pvc-1", "NodeStageVolume", "node")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			unlock()
+			var next func()
+			if relock {
+				next, err = locks.TryLock("pvc-1", "NodeUnstageVolume", "node")
+				Expect(err).ShouldNot(HaveOccurred())
+			}
+
+			// unlock of a released operation must never release the
+			// lock held by the next operation on the volume
+			for i := 1; i < unlocks; i++ {
+				unlock()
+			}
+
+			op, ok := locked("pvc-1")
+			Expect(ok).Should(Equal(relock))
+			if relock {
+				Expect(op.Type).Should(Equal("NodeUnstageVolume"))
+				next()
+				_, ok = locked("pvc-1")
+				Expect(ok).Should(BeFalse())
+			}
+		},
+		Entry("single unlock releases the volume", 1, false),
+		Entry("repeated unlock is a no-op", 3, false),
+		Entry("repeated unlock keeps the next operation locked", 3, true),
+	)
+
+	DescribeTable("Stuck",
+		func(ages map[string]time.Duration, d time.Duration, stuck []string) {
+			for id, age := range ages {
+				_, err := locks.TryLock(id, "NodeStageVolume", "node")
+				Expect(err).ShouldNot(HaveOccurred())
+				locks.ops[id].Started = time.Now().Add(-age)
+			}
+
+			var ids []string
+			for _, op := range locks.Stuck(d) {
+				ids = append(ids, op.VolumeID)
+			}
+			Expect(ids).Should(Equal(stuck))
+		},
+		Entry("no operations", nil, time.Minute, nil),
+		Entry("recent operations are not stuck",
+			map[string]time.Duration{"pvc-1": time.Second, "pvc-2": 10 * time.Second},
+			time.Minute, nil),
+		Entry("old operations are stuck, oldest first",
+			map[string]time.Duration{"pvc-1": 2 * time.Minute, "pvc-2": time.Second, "pvc-3": 5 * time.Minute},
+			time.Minute, []string{"pvc-3", "pvc-1"}),
+	)
+})